@@ -0,0 +1,258 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/voiplens/kamailio_exporter/collector"
+	"github.com/voiplens/kamailio_exporter/config"
+)
+
+// Exit codes and statuses of a Nagios plugin.
+const (
+	checkOK = iota
+	checkWarning
+	checkCritical
+	checkUnknown
+)
+
+var checkStatuses = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// checkOptions are the arguments of the check command.
+type checkOptions struct {
+	metric     string
+	labels     map[string]string
+	warn, crit string
+	configFile string
+}
+
+// thresholdRange is a threshold in the range format of the Nagios plugin guidelines:
+// "10" alerts outside of 0..10, "10:" below 10, "~:10" above 10, "10:20" outside of 10..20
+// and "@10:20" within 10..20.
+type thresholdRange struct {
+	start, end float64
+	inside     bool
+	text       string
+}
+
+func parseThresholdRange(text string) (*thresholdRange, error) {
+	if text == "" {
+		return nil, nil
+	}
+	r := &thresholdRange{start: 0, end: math.Inf(1), text: text}
+	value := text
+	if strings.HasPrefix(value, "@") {
+		r.inside = true
+		value = value[1:]
+	}
+
+	start, end, found := strings.Cut(value, ":")
+	if !found {
+		start, end = "", start
+	}
+	var err error
+	switch start {
+	case "":
+	case "~":
+		r.start = math.Inf(-1)
+	default:
+		if r.start, err = strconv.ParseFloat(start, 64); err != nil {
+			return nil, fmt.Errorf("invalid threshold %q: %w", text, err)
+		}
+	}
+	if end != "" {
+		if r.end, err = strconv.ParseFloat(end, 64); err != nil {
+			return nil, fmt.Errorf("invalid threshold %q: %w", text, err)
+		}
+	}
+	if r.start > r.end {
+		return nil, fmt.Errorf("invalid threshold %q: start is greater than end", text)
+	}
+	return r, nil
+}
+
+// alert reports whether the value triggers the threshold.
+func (r *thresholdRange) alert(v float64) bool {
+	if r == nil {
+		return false
+	}
+	within := v >= r.start && v <= r.end
+	return within == r.inside
+}
+
+func (r *thresholdRange) String() string {
+	if r == nil {
+		return ""
+	}
+	return r.text
+}
+
+// runCheck runs the collectors once and checks the series of a metric against the thresholds,
+// printing the result and the performance data in the format of a Nagios plugin.
+// It returns the exit code of the plugin.
+func runCheck(flags *collector.KamailioCollectorConfig, opts checkOptions, logger log.Logger) int {
+	status, output := check(flags, opts, logger)
+	fmt.Printf("KAMAILIO %s - %s\n", checkStatuses[status], output)
+	return status
+}
+
+func check(flags *collector.KamailioCollectorConfig, opts checkOptions, logger log.Logger) (int, string) {
+	warn, err := parseThresholdRange(opts.warn)
+	if err != nil {
+		return checkUnknown, err.Error()
+	}
+	crit, err := parseThresholdRange(opts.crit)
+	if err != nil {
+		return checkUnknown, err.Error()
+	}
+
+	sc := config.NewSafeConfig(nil)
+	if opts.configFile != "" {
+		if err := sc.ReloadConfig(opts.configFile, nil); err != nil {
+			return checkUnknown, fmt.Sprintf("can not load the configuration: %s", err)
+		}
+	}
+	collectorConfig := scrapeConfig(flags, sc.Config())
+	// a check is never served from a background scrape
+	collectorConfig.ScrapeInterval = nil
+	c, err := collector.NewKamailioCollector(collectorConfig, logger)
+	if err != nil {
+		return checkUnknown, fmt.Sprintf("can not create the collector: %s", err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	if collectorConfig.Timeout != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *collectorConfig.Timeout)
+		defer cancel()
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c.WithContext(ctx))
+	// the metrics gathered despite an error, e.g. a scripted metric clashing with another one, are still checked
+	families, gatherErr := registry.Gather()
+	if gatherErr != nil {
+		level.Warn(logger).Log("msg", "Error gathering the metrics", "err", gatherErr)
+	}
+	if up := findSeries(families, "kamailio_up", nil); len(up) == 0 || up[0].value == 0 {
+		return checkUnknown, "can not scrape kamailio"
+	}
+	series := findSeries(families, opts.metric, opts.labels)
+	if len(series) == 0 {
+		if gatherErr != nil {
+			return checkUnknown, fmt.Sprintf("can not collect the metrics: %s", gatherErr)
+		}
+		return checkUnknown, fmt.Sprintf("no series of %s found", opts.metric)
+	}
+
+	// the status is the worst status of the series
+	status := checkOK
+	var values, perfdata []string
+	for _, s := range series {
+		switch {
+		case crit.alert(s.value):
+			status = max(status, checkCritical)
+		case warn.alert(s.value):
+			status = max(status, checkWarning)
+		}
+		value := strconv.FormatFloat(s.value, 'f', -1, 64)
+		values = append(values, s.name+"="+value)
+		perfdata = append(perfdata, fmt.Sprintf("'%s'=%s;%s;%s", s.perfLabel, value, warn, crit))
+	}
+	return status, strings.Join(values, ", ") + " | " + strings.Join(perfdata, " ")
+}
+
+// checkedSeries is a series of the checked metric.
+type checkedSeries struct {
+	// name is the metric name with its labels, as shown by Prometheus.
+	name string
+	// perfLabel is the name without the characters performance data labels can not hold.
+	perfLabel string
+	value     float64
+}
+
+// findSeries returns the series of the metric having the given label values, sorted by name.
+func findSeries(families []*dto.MetricFamily, metric string, labels map[string]string) []checkedSeries {
+	var series []checkedSeries
+	for _, family := range families {
+		if family.GetName() != metric {
+			continue
+		}
+	metrics:
+		for _, m := range family.GetMetric() {
+			pairs := make(map[string]string)
+			for _, pair := range m.GetLabel() {
+				pairs[pair.GetName()] = pair.GetValue()
+			}
+			for name, value := range labels {
+				if pairs[name] != value {
+					continue metrics
+				}
+			}
+
+			var value float64
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				value = m.GetCounter().GetValue()
+			case dto.MetricType_GAUGE:
+				value = m.GetGauge().GetValue()
+			case dto.MetricType_UNTYPED:
+				value = m.GetUntyped().GetValue()
+			default:
+				continue
+			}
+			series = append(series, checkedSeries{
+				name:      seriesName(metric, m.GetLabel(), "=", strconv.Quote),
+				perfLabel: seriesName(metric, m.GetLabel(), ":", perfLabelValue),
+				value:     value,
+			})
+		}
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].name < series[j].name })
+	return series
+}
+
+func seriesName(metric string, labels []*dto.LabelPair, separator string, quote func(string) string) string {
+	if len(labels) == 0 {
+		return metric
+	}
+	pairs := make([]string, 0, len(labels))
+	for _, pair := range labels {
+		pairs = append(pairs, pair.GetName()+separator+quote(pair.GetValue()))
+	}
+	return metric + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// perfLabelValue replaces the equal signs and single quotes, which performance data labels can not hold.
+func perfLabelValue(value string) string {
+	return strings.NewReplacer("=", "_", "'", "_").Replace(value)
+}
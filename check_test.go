@@ -0,0 +1,125 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseThresholdRange(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		// alerts and passes are values triggering the threshold, and values not triggering it
+		alerts, passes []float64
+		invalid        bool
+	}{
+		{text: "10", alerts: []float64{-1, 10.5, 11}, passes: []float64{0, 5, 10}},
+		{text: "10:", alerts: []float64{-1, 9.9}, passes: []float64{10, 11, math.Inf(1)}},
+		{text: "~:10", alerts: []float64{10.1, 100}, passes: []float64{math.Inf(-1), -5, 0, 10}},
+		{text: "10:20", alerts: []float64{9, 21}, passes: []float64{10, 15, 20}},
+		{text: "@10:20", alerts: []float64{10, 15, 20}, passes: []float64{9, 21}},
+		{text: "", passes: []float64{-1, 0, 1e9}},
+		{text: "abc", invalid: true},
+		{text: "1:abc", invalid: true},
+		{text: "20:10", invalid: true},
+	} {
+		r, err := parseThresholdRange(tc.text)
+		if tc.invalid {
+			if err == nil {
+				t.Errorf("parseThresholdRange(%q) = %+v, want an error", tc.text, r)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseThresholdRange(%q): %v", tc.text, err)
+			continue
+		}
+		if got := r.String(); got != tc.text {
+			t.Errorf("parseThresholdRange(%q).String() = %q", tc.text, got)
+		}
+		for _, v := range tc.alerts {
+			if !r.alert(v) {
+				t.Errorf("threshold %q does not alert on %v", tc.text, v)
+			}
+		}
+		for _, v := range tc.passes {
+			if r.alert(v) {
+				t.Errorf("threshold %q alerts on %v", tc.text, v)
+			}
+		}
+	}
+}
+
+func TestFindSeries(t *testing.T) {
+	targets := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "kamailio_dispatcher_list_target"}, []string{"destination", "set_id"})
+	targets.WithLabelValues("sip:10.0.0.2", "1").Set(0)
+	targets.WithLabelValues("sip:10.0.0.1", "1").Set(1)
+	targets.WithLabelValues("sip:10.0.0.1", "2").Set(1)
+	up := prometheus.NewGauge(prometheus.GaugeOpts{Name: "kamailio_up"})
+	up.Set(1)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(targets, up)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		metric string
+		labels map[string]string
+		want   []string
+	}{
+		{"kamailio_up", nil, []string{"kamailio_up"}},
+		{"kamailio_dispatcher_list_target", nil, []string{
+			`kamailio_dispatcher_list_target{destination="sip:10.0.0.1",set_id="1"}`,
+			`kamailio_dispatcher_list_target{destination="sip:10.0.0.1",set_id="2"}`,
+			`kamailio_dispatcher_list_target{destination="sip:10.0.0.2",set_id="1"}`,
+		}},
+		{"kamailio_dispatcher_list_target", map[string]string{"destination": "sip:10.0.0.1"}, []string{
+			`kamailio_dispatcher_list_target{destination="sip:10.0.0.1",set_id="1"}`,
+			`kamailio_dispatcher_list_target{destination="sip:10.0.0.1",set_id="2"}`,
+		}},
+		{"kamailio_dispatcher_list_target", map[string]string{"destination": "sip:10.0.0.1", "set_id": "2"}, []string{
+			`kamailio_dispatcher_list_target{destination="sip:10.0.0.1",set_id="2"}`,
+		}},
+		{"kamailio_dispatcher_list_target", map[string]string{"destination": "sip:10.0.0.3"}, nil},
+		{"kamailio_dispatcher_list_target", map[string]string{"missing": "x"}, nil},
+		{"kamailio_unknown", nil, nil},
+	} {
+		var got []string
+		for _, s := range findSeries(families, tc.metric, tc.labels) {
+			got = append(got, s.name)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("findSeries(%s, %v) = %q, want %q", tc.metric, tc.labels, got, tc.want)
+		}
+	}
+
+	series := findSeries(families, "kamailio_dispatcher_list_target", map[string]string{"set_id": "2"})
+	if len(series) != 1 || series[0].value != 1 || series[0].perfLabel != "kamailio_dispatcher_list_target{destination:sip:10.0.0.1,set_id:2}" {
+		t.Errorf("got series %+v", series)
+	}
+}
@@ -0,0 +1,411 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package binrpctest provides a BINRPC server answering canned replies, to test the collectors
+// without a running Kamailio.
+package binrpctest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// BINRPC packet and record constants, as defined by the ctl module of Kamailio.
+const (
+	magic   = 0xA
+	version = 1
+
+	flagReply = 1
+	flagError = 2
+
+	typeInt    = 0
+	typeString = 1
+	typeDouble = 2
+	typeStruct = 3
+	typeArray  = 4
+	typeAVP    = 5
+
+	// maxPacketSize is the size of the largest request read from a client.
+	maxPacketSize = 1 << 24
+)
+
+// Struct is a BINRPC struct value, made of members in order. Member names may be repeated,
+// as in the replies of dispatcher.list.
+type Struct []Member
+
+// Member is a named value of a Struct.
+type Member struct {
+	Name  string
+	Value any
+}
+
+// Array is a BINRPC array value.
+type Array []any
+
+// Fault is an error replied to a call, as Kamailio does for failed commands.
+type Fault struct {
+	Code    int
+	Message string
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("%d %s", f.Code, f.Message)
+}
+
+// Handler answers a call with the records of the reply. The records may be of type int, float64,
+// string, Struct or Array, nested in any way. An error other than a *Fault is replied with code 500.
+type Handler func(args ...string) ([]any, error)
+
+// Server is a BINRPC server listening on a local address.
+// Unknown methods are replied with a fault, and system.listMethods lists the handled methods
+// unless it has a handler itself.
+type Server struct {
+	// URI is the address of the server, in the format of --kamailio.rpc-uri.
+	URI      string
+	Listener net.Listener
+
+	mtx      sync.Mutex
+	handlers map[string]Handler
+	calls    [][]string
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a server on a TCP port of the loopback interface.
+func NewServer() *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("binrpctest: failed to listen on a port: %v", err))
+	}
+	return newServer(l, "tcp://"+l.Addr().String())
+}
+
+// NewUnixServer starts a server on a unix socket created at path.
+func NewUnixServer(path string) *Server {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		panic(fmt.Sprintf("binrpctest: failed to listen on %s: %v", path, err))
+	}
+	return newServer(l, "unix://"+path)
+}
+
+func newServer(l net.Listener, uri string) *Server {
+	s := &Server{
+		URI:      uri,
+		Listener: l,
+		handlers: make(map[string]Handler),
+		conns:    make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Handle registers the handler of the method, replacing any previous one.
+func (s *Server) Handle(method string, handler Handler) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.handlers[method] = handler
+}
+
+// Reply registers a handler answering the method with the given records, whatever its arguments.
+func (s *Server) Reply(method string, records ...any) {
+	s.Handle(method, func(...string) ([]any, error) {
+		return records, nil
+	})
+}
+
+// Calls returns the calls received so far, each one being the method followed by its arguments.
+func (s *Server) Calls() [][]string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return slices.Clone(s.calls)
+}
+
+// Close stops the server and closes the connections of the clients.
+func (s *Server) Close() {
+	s.Listener.Close()
+	s.mtx.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mtx.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.Listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mtx.Lock()
+		s.conns[conn] = struct{}{}
+		s.mtx.Unlock()
+		s.wg.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mtx.Lock()
+		delete(s.conns, conn)
+		s.mtx.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		cookie, values, err := readRequest(r)
+		if err != nil {
+			return
+		}
+		records, err := s.call(values)
+		reply, err := encodeReply(cookie, records, err)
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write(reply); err != nil {
+			return
+		}
+	}
+}
+
+// call runs the handler of the method in values[0].
+func (s *Server) call(values []string) ([]any, error) {
+	if len(values) == 0 {
+		return nil, &Fault{Code: 400, Message: "method name missing"}
+	}
+	method, args := values[0], values[1:]
+
+	s.mtx.Lock()
+	s.calls = append(s.calls, values)
+	handler, ok := s.handlers[method]
+	if !ok && method == "system.listMethods" {
+		handler, ok = s.listMethods(), true
+	}
+	s.mtx.Unlock()
+
+	if !ok {
+		return nil, &Fault{Code: 500, Message: "command " + method + " not found"}
+	}
+	return handler(args...)
+}
+
+// listMethods returns a handler listing the handled methods. It must be called with the lock held.
+func (s *Server) listMethods() Handler {
+	methods := []any{"system.listMethods"}
+	for method := range s.handlers {
+		methods = append(methods, method)
+	}
+	return func(...string) ([]any, error) {
+		return methods, nil
+	}
+}
+
+// readRequest reads a request packet, and returns its cookie and its records as strings.
+func readRequest(r io.Reader) (uint32, []string, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	if header[0]>>4 != magic || header[0]&0xf != version {
+		return 0, nil, fmt.Errorf("invalid BINRPC header %#x", header[0])
+	}
+	length, err := readUint(r, int(header[1]>>2&3)+1)
+	if err != nil {
+		return 0, nil, err
+	}
+	cookie, err := readUint(r, int(header[1]&3)+1)
+	if err != nil {
+		return 0, nil, err
+	}
+	if length > maxPacketSize {
+		return 0, nil, fmt.Errorf("BINRPC packet too large: %d bytes", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+
+	var values []string
+	br := bytes.NewReader(body)
+	for br.Len() > 0 {
+		value, err := readValue(br)
+		if err != nil {
+			return 0, nil, err
+		}
+		values = append(values, value)
+	}
+	return uint32(cookie), values, nil
+}
+
+// readValue reads a scalar record of a request as a string.
+func readValue(r *bytes.Reader) (string, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	size := int(header >> 4 & 7)
+	if header&0x80 != 0 {
+		n, err := readUint(r, size)
+		if err != nil {
+			return "", err
+		}
+		size = int(n)
+	}
+	if size > r.Len() {
+		return "", io.ErrUnexpectedEOF
+	}
+	content := make([]byte, size)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return "", err
+	}
+
+	switch header & 0xf {
+	case typeString:
+		return string(bytes.TrimRight(content, "\x00")), nil
+	case typeInt:
+		return strconv.Itoa(int(int32(decodeUint(content)))), nil
+	case typeDouble:
+		return strconv.FormatFloat(float64(int32(decodeUint(content)))/1000, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("unsupported BINRPC record type %d in request", header&0xf)
+}
+
+func readUint(r io.Reader, size int) (uint64, error) {
+	content := make([]byte, size)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return 0, err
+	}
+	return decodeUint(content), nil
+}
+
+func decodeUint(content []byte) uint64 {
+	var v uint64
+	for _, b := range content {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+// encodeReply returns the reply packet of a call, a fault if err is not nil.
+func encodeReply(cookie uint32, records []any, err error) ([]byte, error) {
+	flags := byte(flagReply)
+	if err != nil {
+		var fault *Fault
+		if !errors.As(err, &fault) {
+			fault = &Fault{Code: 500, Message: err.Error()}
+		}
+		flags |= flagError
+		records = []any{fault.Code, fault.Message}
+	}
+
+	var body bytes.Buffer
+	for _, record := range records {
+		if err := encodeRecord(&body, record); err != nil {
+			return nil, err
+		}
+	}
+
+	// the length and the cookie are always written on 4 bytes
+	var packet bytes.Buffer
+	packet.WriteByte(magic<<4 | version)
+	packet.WriteByte(flags<<4 | 3<<2 | 3)
+	binary.Write(&packet, binary.BigEndian, uint32(body.Len()))
+	binary.Write(&packet, binary.BigEndian, cookie)
+	packet.Write(body.Bytes())
+	return packet.Bytes(), nil
+}
+
+func encodeRecord(w *bytes.Buffer, record any) error {
+	switch v := record.(type) {
+	case int:
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return fmt.Errorf("int %d overflows a BINRPC int", v)
+		}
+		encodeInt(w, typeInt, int32(v))
+	case float64:
+		encodeInt(w, typeDouble, int32(v*1000))
+	case string:
+		encodeString(w, typeString, v)
+	case Struct:
+		w.WriteByte(typeStruct)
+		for _, member := range v {
+			encodeString(w, typeAVP, member.Name)
+			if err := encodeRecord(w, member.Value); err != nil {
+				return err
+			}
+		}
+		w.WriteByte(0x80 | typeStruct)
+	case Array:
+		w.WriteByte(typeArray)
+		for _, item := range v {
+			if err := encodeRecord(w, item); err != nil {
+				return err
+			}
+		}
+		w.WriteByte(0x80 | typeArray)
+	default:
+		return fmt.Errorf("unsupported BINRPC record %T", record)
+	}
+	return nil
+}
+
+// encodeInt writes the significant bytes of an int or a double, the latter being sent in thousandths.
+func encodeInt(w *bytes.Buffer, typ byte, v int32) {
+	var content []byte
+	for u := uint32(v); u != 0; u >>= 8 {
+		content = append([]byte{byte(u)}, content...)
+	}
+	writeHeader(w, typ, len(content))
+	w.Write(content)
+}
+
+// encodeString writes a null terminated string.
+func encodeString(w *bytes.Buffer, typ byte, v string) {
+	writeHeader(w, typ, len(v)+1)
+	w.WriteString(v)
+	w.WriteByte(0)
+}
+
+// writeHeader writes the header of a record, with the size inline when it fits in 3 bits.
+func writeHeader(w *bytes.Buffer, typ byte, size int) {
+	if size < 8 {
+		w.WriteByte(byte(size)<<4 | typ)
+		return
+	}
+	w.WriteByte(0x80 | 4<<4 | typ)
+	binary.Write(w, binary.BigEndian, uint32(size))
+}
@@ -0,0 +1,144 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package binrpctest_test
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/voiplens/kamailio_exporter/collector/binrpctest"
+	"go.voiplens.io/kamailio/binrpc"
+)
+
+// call sends a request with the binrpc package and reads its reply.
+func call(t *testing.T, conn net.Conn, values ...string) ([]binrpc.Record, error) {
+	t.Helper()
+	cookie, err := binrpc.WritePacket(conn, values...)
+	if err != nil {
+		t.Fatalf("writing the request: %v", err)
+	}
+	return binrpc.ReadPacket(conn, cookie)
+}
+
+func TestServerRoundTrip(t *testing.T) {
+	server := binrpctest.NewServer()
+	defer server.Close()
+	server.Reply("core.test",
+		42,
+		-7,
+		1.5,
+		"text",
+		binrpctest.Struct{
+			{Name: "DEST", Value: "a"},
+			{Name: "DEST", Value: "b"},
+			{Name: "NESTED", Value: binrpctest.Struct{{Name: "LATENCY", Value: 0.25}}},
+		},
+	)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URI, "tcp://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	records, err := call(t, conn, "core.test", "arg", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 5 {
+		t.Fatalf("got %d records, want 5", len(records))
+	}
+	for i, want := range []int{42, -7} {
+		if got, err := records[i].Int(); err != nil || got != want {
+			t.Errorf("record %d = %v (%v), want %d", i, got, err, want)
+		}
+	}
+	if got, err := records[2].Double(); err != nil || got != 1.5 {
+		t.Errorf("record 2 = %v (%v), want 1.5", got, err)
+	}
+	if got, err := records[3].String(); err != nil || got != "text" {
+		t.Errorf("record 3 = %q (%v), want %q", got, err, "text")
+	}
+
+	items, err := records[4].StructItems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, item := range items {
+		keys = append(keys, item.Key)
+	}
+	if want := []string{"DEST", "DEST", "NESTED"}; !slices.Equal(keys, want) {
+		t.Fatalf("got struct keys %v, want %v", keys, want)
+	}
+	if got, err := items[1].Value.String(); err != nil || got != "b" {
+		t.Errorf("second DEST = %q (%v), want %q", got, err, "b")
+	}
+	nested, err := items[2].Value.StructItems()
+	if err != nil || len(nested) != 1 {
+		t.Fatalf("got nested struct %v (%v), want one member", nested, err)
+	}
+	if got, err := nested[0].Value.Double(); err != nil || got != 0.25 {
+		t.Errorf("nested LATENCY = %v (%v), want 0.25", got, err)
+	}
+
+	if got, want := server.Calls(), [][]string{{"core.test", "arg", ""}}; !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("got calls %q, want %q", got, want)
+	}
+}
+
+func TestServerFaults(t *testing.T) {
+	server := binrpctest.NewServer()
+	defer server.Close()
+	server.Handle("stats.fetch", func(args ...string) ([]any, error) {
+		return nil, &binrpctest.Fault{Code: 400, Message: "invalid group " + args[0]}
+	})
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URI, "tcp://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, values := range [][]string{{"stats.fetch", "bad:"}, {"core.unknown"}} {
+		if records, err := call(t, conn, values...); err == nil {
+			t.Errorf("%s: got records %v, want a fault", values[0], records)
+		}
+	}
+
+	// the connection is still usable after a fault
+	records, err := call(t, conn, "system.listMethods")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var methods []string
+	for _, record := range records {
+		method, _ := record.String()
+		methods = append(methods, method)
+	}
+	slices.Sort(methods)
+	if want := []string{"stats.fetch", "system.listMethods"}; !slices.Equal(methods, want) {
+		t.Errorf("got methods %v, want %v", methods, want)
+	}
+}
@@ -0,0 +1,144 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package collector
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scrapeCache scrapes Kamailio in the background and keeps the metrics of the last scrape,
+// which are served to Prometheus instead of running the collectors on every request.
+// When a collector fails, its previous metrics are still served until they are older than maxAge.
+type scrapeCache struct {
+	maxAge time.Duration
+	logger log.Logger
+	quit   chan struct{}
+	done   chan struct{}
+
+	mtx     sync.RWMutex
+	common  []prometheus.Metric
+	results map[string]cachedResult
+}
+
+// cachedResult is the last result of a collector, with the time of its last success.
+type cachedResult struct {
+	scrapeResult
+	timestamp time.Time
+}
+
+func newScrapeCache(maxAge time.Duration, logger log.Logger) *scrapeCache {
+	return &scrapeCache{
+		maxAge:  maxAge,
+		logger:  logger,
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+		results: make(map[string]cachedResult),
+	}
+}
+
+// run scrapes Kamailio every interval with the collector, until stop is called.
+func (c *scrapeCache) run(n *KamailioCollector, interval time.Duration) {
+	defer close(c.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		begin := time.Now()
+		c.update(n.scrape(context.Background()))
+		level.Debug(c.logger).Log("msg", "Background scrape done", "duration_seconds", time.Since(begin).Seconds())
+
+		select {
+		case <-ticker.C:
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// stop ends the background scrapes and waits for the running one.
+func (c *scrapeCache) stop() {
+	close(c.quit)
+	<-c.done
+}
+
+// update stores the results of a scrape. A collector which failed or did not run keeps
+// its previous metrics as long as they are not older than maxAge.
+func (c *scrapeCache) update(common []prometheus.Metric, results []scrapeResult) {
+	now := time.Now()
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	previous := c.results
+	c.common = common
+	c.results = make(map[string]cachedResult, len(previous))
+	for _, result := range results {
+		if result.success {
+			c.results[result.name] = cachedResult{scrapeResult: result, timestamp: now}
+			continue
+		}
+		cached, ok := previous[result.name]
+		if !ok || now.Sub(cached.timestamp) > c.maxAge {
+			c.results[result.name] = cachedResult{scrapeResult: result}
+			continue
+		}
+		// serve the previous metrics, along with the status of the failed scrape
+		cached.success = false
+		cached.status = result.status
+		c.results[result.name] = cached
+	}
+	for name, cached := range previous {
+		if _, ok := c.results[name]; ok || now.Sub(cached.timestamp) > c.maxAge {
+			continue
+		}
+		// without a scrape there is no status to report
+		cached.status = nil
+		c.results[name] = cached
+	}
+}
+
+// collect sends the cached metrics of the given collectors.
+func (c *scrapeCache) collect(collectors map[string]Collector, ch chan<- prometheus.Metric) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	for _, m := range c.common {
+		ch <- m
+	}
+	for name := range collectors {
+		cached, ok := c.results[name]
+		if !ok {
+			continue
+		}
+		for _, m := range slices.Concat(cached.status, cached.metrics) {
+			ch <- m
+		}
+		if !cached.timestamp.IsZero() {
+			ch <- prometheus.MustNewConstMetric(lastScrapeTimestampDesc, prometheus.GaugeValue, float64(cached.timestamp.UnixNano())/1e9, name)
+		}
+	}
+}
@@ -22,18 +22,22 @@
 package collector
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"net/url"
+	"os"
+	"regexp"
 	"slices"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/alecthomas/kingpin/v2"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
-	"go.voiplens.io/kamailio/binrpc"
 )
 
 // Exporter namespace.
@@ -52,22 +56,36 @@ var (
 		[]string{"collector"},
 		nil,
 	)
+	scrapeTimeoutDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_timeout"),
+		"kamailio_exporter: Whether a collector was abandoned because it exceeded its timeout.",
+		[]string{"collector"},
+		nil,
+	)
 	kamailioDialFailureDesc = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "failure_total"),
 		"kamailio_exporter: Counter of a Dial failures.",
 		[]string{},
 		nil,
 	)
+	kamailioReconnectsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "reconnects_total"),
+		"kamailio_exporter: Counter of the connections dialed again after a previous one was lost.",
+		[]string{},
+		nil,
+	)
 	kamailioUpDesc = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "up"),
 		"kamailio_exporter: Whether the Kamailio endpoint is up.",
 		[]string{},
 		nil,
 	)
-)
-
-var (
-	dialErrorCounter = 0
+	lastScrapeTimestampDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "last_scrape_timestamp_seconds"),
+		"kamailio_exporter: Timestamp of the last successful background scrape of a collector, whose metrics are served.",
+		[]string{"collector"},
+		nil,
+	)
 )
 
 const (
@@ -75,100 +93,469 @@ const (
 	defaultDisabled = false
 )
 
+// Defaults of the optional settings of a KamailioCollectorConfig.
+const (
+	defaultMaxConnections = 1
+	defaultScrapeMaxAge   = time.Minute
+)
+
 var (
 	factories              = make(map[string]func(config *KamailioCollectorConfig, logger log.Logger) (Collector, error))
 	initiatedCollectorsMtx = sync.Mutex{}
 	initiatedCollectors    = make(map[string]Collector)
-	collectorStateGlobal   = make(map[string]bool)
+	collectorStateGlobal   = make(map[string]*bool)
 	availableCollectors    = make([]string, 0)
+	forcedCollectors       = make(map[string]bool) // collectors which have been explicitly enabled or disabled
 )
 
 func registerCollector(collector string, isDefaultEnabled bool, factory func(config *KamailioCollectorConfig, logger log.Logger) (Collector, error)) {
+	var helpDefaultState string
+	if isDefaultEnabled {
+		helpDefaultState = "enabled"
+	} else {
+		helpDefaultState = "disabled"
+	}
+
+	flagName := fmt.Sprintf("collector.%s", collector)
+	flagHelp := fmt.Sprintf("Enable the %s collector (default: %s).", collector, helpDefaultState)
+	defaultValue := fmt.Sprintf("%v", isDefaultEnabled)
+
+	flag := kingpin.Flag(flagName, flagHelp).Default(defaultValue).Action(collectorFlagAction(collector)).Bool()
+
 	availableCollectors = append(availableCollectors, collector)
-	collectorStateGlobal[collector] = isDefaultEnabled
+	collectorStateGlobal[collector] = flag
 	factories[collector] = factory
 }
 
+// DisableDefaultCollectors sets the collector state to false for all collectors which
+// have not been explicitly enabled on the command line.
+func DisableDefaultCollectors() {
+	for c := range collectorStateGlobal {
+		if _, ok := forcedCollectors[c]; !ok {
+			*collectorStateGlobal[c] = false
+		}
+	}
+}
+
+// collectorFlagAction generates a new action function for the given collector
+// to track whether it has been explicitly enabled or disabled from the command line.
+// A new action function is needed for each collector flag because the ParseContext
+// does not contain information about which flag called the action.
+// See: https://github.com/alecthomas/kingpin/issues/294
+func collectorFlagAction(collector string) func(ctx *kingpin.ParseContext) error {
+	return func(ctx *kingpin.ParseContext) error {
+		forcedCollectors[collector] = true
+		return nil
+	}
+}
+
 // KamailioCollector implements the prometheus.Collector interface.
 type KamailioCollector struct {
 	Collectors map[string]Collector
 	timeout    time.Duration
-	url        *url.URL
+	timeouts   map[string]time.Duration
+	pool       *connectionPool
+	cache      *scrapeCache
+	group      *scrapeGroup
+	ctx        context.Context
 	logger     log.Logger
 }
 
 // NewKamailioCollector creates a new NodeCollector.
 func NewKamailioCollector(config *KamailioCollectorConfig, logger log.Logger) (*KamailioCollector, error) {
 	// fill the Collector struct
-	url, err := url.Parse(*config.BinrpcURI)
+	dial, err := configDialer(config, logger)
 	if err != nil {
-		return nil, fmt.Errorf("cannot parse URI: %w", err)
+		return nil, err
+	}
+
+	// an explicit collector selection takes precedence over the command-line state
+	enabledCollectors := config.Collectors
+	if len(enabledCollectors) == 0 {
+		enabledCollectors = make(map[string]bool)
+		for key, enabled := range collectorStateGlobal {
+			enabledCollectors[key] = *enabled
+		}
 	}
 
 	collectors := make(map[string]Collector)
 
 	initiatedCollectorsMtx.Lock()
 	defer initiatedCollectorsMtx.Unlock()
-	for key, enabled := range collectorStateGlobal {
+	for key, enabled := range enabledCollectors {
 		if !enabled {
 			continue
 		}
-		collector, err := factories[key](config, log.With(logger, "collector", key))
+		factory, ok := factories[key]
+		if !ok {
+			return nil, unknownCollectorError(key)
+		}
+		collector, err := factory(config, log.With(logger, "collector", key))
 		if err != nil {
 			return nil, err
 		}
 		collectors[key] = collector
 		initiatedCollectors[key] = collector
 	}
-	return &KamailioCollector{Collectors: collectors, logger: logger, url: url, timeout: *config.Timeout}, nil
+	// the collectors declared in the configuration are always enabled
+	for _, rc := range config.RPCCollectors {
+		name := rc.CollectorName()
+		if _, ok := collectors[name]; ok {
+			return nil, fmt.Errorf("rpc collector %q is declared twice", name)
+		}
+		collector, err := newRPCCollector(rc, log.With(logger, "collector", name))
+		if err != nil {
+			return nil, err
+		}
+		collectors[name] = collector
+	}
+	// the collectors are registered with a new registry on every scrape:
+	// registering them now reports the conflicting descs before any scrape
+	if err := prometheus.NewRegistry().Register(KamailioCollector{Collectors: collectors}); err != nil {
+		return nil, fmt.Errorf("inconsistent collector metrics: %w", err)
+	}
+	// the scripted metrics are not checked by the registry, they must not take the name of a described metric
+	reservedNames := describedNames(KamailioCollector{Collectors: collectors})
+	for _, collector := range collectors {
+		if c, ok := collector.(*StatsFetchCollector); ok {
+			c.reservedNames = reservedNames
+		}
+	}
+	maxConns := defaultMaxConnections
+	if config.MaxConnections != nil {
+		maxConns = *config.MaxConnections
+	}
+	kc := &KamailioCollector{
+		Collectors: collectors,
+		logger:     logger,
+		timeout:    *config.Timeout,
+		timeouts:   config.CollectorTimeouts,
+		pool:       newConnectionPool(dial, maxConns, logger),
+	}
+	var minInterval time.Duration
+	if config.MinScrapeInterval != nil {
+		minInterval = *config.MinScrapeInterval
+	}
+	kc.group = newScrapeGroup(minInterval)
+	if config.ScrapeInterval != nil && *config.ScrapeInterval > 0 {
+		maxAge := defaultScrapeMaxAge
+		if config.ScrapeMaxAge != nil {
+			maxAge = *config.ScrapeMaxAge
+		}
+		kc.cache = newScrapeCache(maxAge, logger)
+		go kc.cache.run(kc, *config.ScrapeInterval)
+	}
+	return kc, nil
+}
+
+// configDialer returns the function opening a transport to Kamailio, or to the recorded replies.
+func configDialer(config *KamailioCollectorConfig, logger log.Logger) (func(context.Context) (transport, error), error) {
+	if config.ReplayDir != nil && *config.ReplayDir != "" {
+		replay, err := loadRecordings(*config.ReplayDir)
+		if err != nil {
+			return nil, err
+		}
+		return func(_ context.Context) (transport, error) {
+			return replay, nil
+		}, nil
+	}
+
+	url, err := url.Parse(*config.RPCURI)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse URI: %w", err)
+	}
+	replyDir := defaultReplyDir
+	if config.ReplyDir != nil && *config.ReplyDir != "" {
+		replyDir = *config.ReplyDir
+	}
+	dial, err := newDialer(url, *config.Timeout, replyDir)
+	if err != nil {
+		return nil, err
+	}
+	if config.RecordDir == nil || *config.RecordDir == "" {
+		return dial, nil
+	}
+	if err := os.MkdirAll(*config.RecordDir, 0o755); err != nil {
+		return nil, err
+	}
+	dir := *config.RecordDir
+	return func(ctx context.Context) (transport, error) {
+		conn, err := dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &recordingTransport{transport: conn, dir: dir, logger: logger}, nil
+	}, nil
+}
+
+// Dial opens a connection to the Kamailio RPC URI of the configuration, or to the recorded replies.
+func Dial(config *KamailioCollectorConfig, logger log.Logger) (Conn, error) {
+	dial, err := configDialer(config, logger)
+	if err != nil {
+		return nil, err
+	}
+	return dial(context.Background())
+}
+
+// Close stops the background scrapes and closes the connections to Kamailio. The collector must not be used afterwards.
+func (n KamailioCollector) Close() error {
+	if n.cache != nil {
+		n.cache.stop()
+	}
+	return n.pool.Close()
+}
+
+// ParseCollectorTimeouts parses the timeouts of the collectors given in the "NAME:DURATION" format.
+func ParseCollectorTimeouts(collectorTimeouts *[]string, logger log.Logger) map[string]time.Duration {
+	timeouts := make(map[string]time.Duration)
+	for _, entry := range *collectorTimeouts {
+		name, value, ok := strings.Cut(entry, ":")
+		if !ok {
+			level.Warn(logger).Log("msg", "Invalid collector timeout. Removing the entry", "timeout", entry)
+			continue
+		}
+		if err := ValidateCollectors(name); err != nil {
+			level.Warn(logger).Log("msg", "Invalid collector timeout. Removing the entry", "timeout", entry, "err", err)
+			continue
+		}
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			level.Warn(logger).Log("msg", "Invalid collector timeout duration. Removing the entry", "timeout", entry, "err", err)
+			continue
+		}
+		timeouts[name] = timeout
+	}
+	return timeouts
+}
+
+// ValidateCollectors returns an error if one of the given collector names is unknown.
+func ValidateCollectors(names ...string) error {
+	for _, name := range names {
+		if _, ok := factories[name]; !ok {
+			return unknownCollectorError(name)
+		}
+	}
+	return nil
+}
+
+func unknownCollectorError(name string) error {
+	return fmt.Errorf("unknown collector %q, available collectors: %s", name, strings.Join(availableCollectors, ", "))
+}
+
+// Filter returns a copy of the collector restricted to the included collectors, minus the excluded ones.
+// An empty include list keeps every enabled collector.
+func (n KamailioCollector) Filter(include, exclude []string) (*KamailioCollector, error) {
+	for _, name := range slices.Concat(include, exclude) {
+		if _, ok := n.Collectors[name]; ok {
+			continue
+		}
+		if err := ValidateCollectors(name); err != nil {
+			return nil, err
+		}
+	}
+
+	collectors := make(map[string]Collector)
+	if len(include) == 0 {
+		for name, c := range n.Collectors {
+			collectors[name] = c
+		}
+	}
+	for _, name := range include {
+		c, ok := n.Collectors[name]
+		if !ok {
+			return nil, fmt.Errorf("collector %q is not enabled", name)
+		}
+		collectors[name] = c
+	}
+	for _, name := range exclude {
+		delete(collectors, name)
+	}
+
+	n.Collectors = collectors
+	return &n, nil
 }
 
-// Describe implements the prometheus.Collector interface.
+// WithContext returns a copy of the collector whose scrapes end by the deadline of the context,
+// if it comes before the collector timeout.
+func (n KamailioCollector) WithContext(ctx context.Context) *KamailioCollector {
+	n.ctx = ctx
+	return &n
+}
+
+// Describe implements the prometheus.Collector interface. It sends the descs of the exporter metrics,
+// along with the descs of the collectors implementing DescribedCollector.
 func (n KamailioCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- scrapeDurationDesc
 	ch <- scrapeSuccessDesc
+	ch <- scrapeTimeoutDesc
+	ch <- kamailioDialFailureDesc
+	ch <- kamailioReconnectsDesc
+	ch <- kamailioUpDesc
+	ch <- lastScrapeTimestampDesc
+	for _, c := range n.Collectors {
+		if d, ok := c.(DescribedCollector); ok {
+			d.Describe(ch)
+		}
+	}
 }
 
 // Collect implements the prometheus.Collector interface.
 func (n KamailioCollector) Collect(ch chan<- prometheus.Metric) {
-	var err error
-	var conn net.Conn
-	address := n.url.Host
+	if n.cache != nil {
+		n.cache.collect(n.Collectors, ch)
+		return
+	}
 
-	if n.url.Scheme == "unix" {
-		address = n.url.Path
+	// a round shared with concurrent scrapes must not be canceled along with this one, only its deadline applies
+	ctx := context.Background()
+	if n.ctx != nil {
+		if deadline, ok := n.ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+			defer cancel()
+		}
 	}
 
-	conn, err = net.DialTimeout(n.url.Scheme, address, n.timeout)
-	if err != nil {
-		level.Error(n.logger).Log("msg", "Can not connect to kamailio", "err", err)
-		dialErrorCounter++
-		ch <- prometheus.MustNewConstMetric(kamailioDialFailureDesc, prometheus.CounterValue, float64(dialErrorCounter))
-		ch <- prometheus.MustNewConstMetric(kamailioUpDesc, prometheus.GaugeValue, 0)
-		return
+	// concurrent scrapes share the RPC calls of the collectors they have in common
+	names := make([]string, 0, len(n.Collectors))
+	for name := range n.Collectors {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	common, results := n.group.do(names, func(names []string) ([]prometheus.Metric, []scrapeResult) {
+		round := n
+		round.Collectors = make(map[string]Collector, len(names))
+		for _, name := range names {
+			round.Collectors[name] = n.Collectors[name]
+		}
+		return round.scrape(ctx)
+	})
+	for _, m := range common {
+		ch <- m
+	}
+	for _, result := range results {
+		for _, m := range slices.Concat(result.status, result.metrics) {
+			ch <- m
+		}
 	}
+}
+
+// scrapeResult holds the metrics produced by a collector during a scrape.
+type scrapeResult struct {
+	name    string
+	success bool
+	// status holds the duration and success metrics of the collector.
+	status  []prometheus.Metric
+	metrics []prometheus.Metric
+}
 
-	err = conn.SetDeadline(time.Now().Add(n.timeout))
+// scrape runs the collectors against Kamailio. It returns the metrics which do not belong to a collector,
+// such as kamailio_up, and the results of the collectors supported by Kamailio.
+// The scrape ends after the collector timeout, or earlier with the context: the collectors
+// which could not start by then are reported as timed out.
+func (n KamailioCollector) scrape(ctx context.Context) ([]prometheus.Metric, []scrapeResult) {
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+
+	var common []prometheus.Metric
+	conn, err := n.pool.acquire(ctx)
+	dialFailures, reconnects := n.pool.stats()
+	common = append(common, prometheus.MustNewConstMetric(kamailioReconnectsDesc, prometheus.CounterValue, reconnects))
 	if err != nil {
-		level.Error(n.logger).Log("msg", "Can not set deadline", "err", err)
+		level.Error(n.logger).Log("msg", "Can not connect to kamailio", "err", err)
+		common = append(common,
+			prometheus.MustNewConstMetric(kamailioDialFailureDesc, prometheus.CounterValue, dialFailures),
+			prometheus.MustNewConstMetric(kamailioUpDesc, prometheus.GaugeValue, 0),
+		)
+		return common, nil
 	}
-	defer conn.Close()
 
-	runtimeMethods, err := listMethods(conn, ch, n.logger)
+	var runtimeMethods []string
+	listed, err := gather(func(ch chan<- prometheus.Metric) (err error) {
+		runtimeMethods, err = listMethods(ctx, conn, ch, n.logger)
+		return err
+	})
+	n.pool.release(conn)
+	common = append(common, listed...)
 	if err != nil {
-		return
+		return common, nil
 	}
 
+	// run the collectors concurrently, as far as the connection pool allows
+	var (
+		wg      sync.WaitGroup
+		mtx     sync.Mutex
+		results []scrapeResult
+	)
 	for name, c := range n.Collectors {
-		if slices.Contains(runtimeMethods, name) {
-			execute(name, c, conn, ch, n.logger)
+		if !slices.Contains(runtimeMethods, collectorMethod(name, c)) {
+			continue
 		}
+		wg.Add(1)
+		go func(name string, c Collector) {
+			defer wg.Done()
+			begin := time.Now()
+			var result scrapeResult
+			conn, err := n.pool.acquire(ctx)
+			if err == nil && ctx.Err() != nil {
+				n.pool.release(conn)
+				err = ctx.Err()
+			}
+			if err != nil && ctx.Err() != nil {
+				level.Error(n.logger).Log("msg", "Scrape timeout reached, skipping collector", "name", name, "err", err)
+				result = scrapeResult{name: name, status: statusMetrics(name, time.Since(begin), false, true)}
+			} else if err != nil {
+				level.Error(n.logger).Log("msg", "Can not get a connection to kamailio", "name", name, "err", err)
+				result = scrapeResult{name: name, status: statusMetrics(name, time.Since(begin), false, false)}
+			} else {
+				// a slow collector only waits for its own timeout, and its connection is dropped
+				// by the pool instead of failing the collectors running after it
+				ctx := ctx
+				if timeout, ok := n.timeouts[name]; ok {
+					var cancel context.CancelFunc
+					ctx, cancel = context.WithTimeout(ctx, timeout)
+					defer cancel()
+				}
+				result = execute(ctx, name, c, conn, n.logger)
+				n.pool.release(conn)
+			}
+			mtx.Lock()
+			results = append(results, result)
+			mtx.Unlock()
+		}(name, c)
 	}
+	wg.Wait()
+	return common, results
+}
+
+// collectorMethod returns the RPC method run by the collector, which is its name unless it tells otherwise.
+func collectorMethod(name string, c Collector) string {
+	if m, ok := c.(interface{ method() string }); ok {
+		return m.method()
+	}
+	return name
+}
+
+// gather returns the metrics sent by fn, along with its error.
+func gather(fn func(ch chan<- prometheus.Metric) error) ([]prometheus.Metric, error) {
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	var metrics []prometheus.Metric
+	go func() {
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		close(done)
+	}()
+	err := fn(ch)
+	close(ch)
+	<-done
+	return metrics, err
 }
 
-func listMethods(conn net.Conn, ch chan<- prometheus.Metric, logger log.Logger) ([]string, error) {
+func listMethods(ctx context.Context, client Client, ch chan<- prometheus.Metric, logger log.Logger) ([]string, error) {
 	begin := time.Now()
-	records, err := getRecords(conn, logger, "system.listMethods")
+	records, err := getRecords(ctx, client, logger, "system.listMethods")
 	if err != nil {
 		ch <- prometheus.MustNewConstMetric(kamailioUpDesc, prometheus.GaugeValue, 0)
 		ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, 0, "system.listMethods")
@@ -186,25 +573,54 @@ func listMethods(conn net.Conn, ch chan<- prometheus.Metric, logger log.Logger)
 	return runtimeMethods, nil
 }
 
-func execute(name string, c Collector, conn net.Conn, ch chan<- prometheus.Metric, logger log.Logger) {
+func execute(ctx context.Context, name string, c Collector, client Client, logger log.Logger) scrapeResult {
 	begin := time.Now()
-	err := c.Update(conn, ch)
+	metrics, err := gather(func(ch chan<- prometheus.Metric) error {
+		return c.Update(ctx, client, ch)
+	})
 	duration := time.Since(begin)
-	var success float64
 
+	if err != nil && isTimeout(ctx, err) {
+		// the metrics sent before the timeout may be incomplete
+		level.Error(logger).Log("msg", "collector timed out", "name", name, "duration_seconds", duration.Seconds(), "err", err)
+		return scrapeResult{name: name, status: statusMetrics(name, duration, false, true)}
+	}
 	if err != nil {
 		if IsNoDataError(err) {
 			level.Debug(logger).Log("msg", "collector returned no data", "name", name, "duration_seconds", duration.Seconds(), "err", err)
 		} else {
 			level.Error(logger).Log("msg", "collector failed", "name", name, "duration_seconds", duration.Seconds(), "err", err)
 		}
-		success = 0
 	} else {
 		level.Debug(logger).Log("msg", "collector succeeded", "name", name, "duration_seconds", duration.Seconds())
-		success = 1
 	}
-	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), name)
-	ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, success, name)
+	return scrapeResult{name: name, success: err == nil, status: statusMetrics(name, duration, err == nil, false), metrics: metrics}
+}
+
+// isTimeout reports whether err was caused by the deadline of the collector. Not every transport
+// error wraps the timeout, so an error returned once the context is done is a timeout as well.
+func isTimeout(ctx context.Context, err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) || ctx.Err() != nil
+}
+
+// statusMetrics returns the duration, success and timeout metrics of a collector.
+func statusMetrics(name string, duration time.Duration, success, timedOut bool) []prometheus.Metric {
+	return []prometheus.Metric{
+		prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), name),
+		prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, boolToFloat(success), name),
+		prometheus.MustNewConstMetric(scrapeTimeoutDesc, prometheus.GaugeValue, boolToFloat(timedOut), name),
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
 }
 
 // ErrNoData indicates the collector found no data to collect, but had no other error.
@@ -214,20 +630,44 @@ func IsNoDataError(err error) bool {
 	return err == ErrNoData
 }
 
+// fqNamePattern extracts the name of a desc, which has no accessor, from its string form.
+var fqNamePattern = regexp.MustCompile(`fqName: "([^"]*)"`)
+
+// describedNames returns the names of the metrics described by the collector.
+func describedNames(c prometheus.Collector) map[string]bool {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+	names := make(map[string]bool)
+	for desc := range ch {
+		if m := fqNamePattern.FindStringSubmatch(desc.String()); m != nil {
+			names[m[1]] = true
+		}
+	}
+	return names
+}
+
 // Collector is the interface a collector has to implement.
 type Collector interface {
 	// Get new metrics and expose them via prometheus registry.
-	Update(conn net.Conn, ch chan<- prometheus.Metric) error
+	// The RPC calls made through the client end with the context.
+	Update(ctx context.Context, client Client, ch chan<- prometheus.Metric) error
 }
 
-func getRecords(conn net.Conn, logger log.Logger, values ...string) ([]binrpc.Record, error) {
-	cookie, err := binrpc.WritePacket(conn, values...)
-	if err != nil {
-		level.Error(logger).Log("msg", "Can not request", "cmd", values[0], "err", err)
-		return nil, err
-	}
+// DescribedCollector is a Collector describing the metrics it sends, so that the registry
+// checks them for conflicts when the KamailioCollector is registered.
+// Metrics created at scrape time, such as the scripted metrics of stats.fetch, can not be described:
+// they are unchecked, and only the consistency of the collected metrics is verified when gathering them.
+type DescribedCollector interface {
+	Collector
+	// Describe sends the descs of the metrics with fixed names and labels.
+	Describe(ch chan<- *prometheus.Desc)
+}
 
-	records, err := binrpc.ReadPacket(conn, cookie)
+func getRecords(ctx context.Context, client Client, logger log.Logger, values ...string) ([]Record, error) {
+	records, err := client.Call(ctx, values[0], values[1:]...)
 	if err != nil {
 		level.Error(logger).Log("msg", "Can not fetch", "cmd", values[0], "err", err)
 		return nil, err
@@ -0,0 +1,371 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package collector
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/voiplens/kamailio_exporter/collector/binrpctest"
+)
+
+// testConfig returns the configuration of a KamailioCollector scraping uri with the given collectors.
+func testConfig(uri string, collectors ...string) *KamailioCollectorConfig {
+	timeout := 5 * time.Second
+	maxConnections := 1
+	config := &KamailioCollectorConfig{
+		RPCURI:         &uri,
+		Timeout:        &timeout,
+		MaxConnections: &maxConnections,
+		Collectors:     make(map[string]bool),
+	}
+	for _, name := range collectors {
+		config.Collectors[name] = true
+	}
+	return config
+}
+
+// gatherMetrics registers the collector with a new registry, gathers it and returns the value
+// of each series, keyed by its name and labels, e.g. `kamailio_up` or `kamailio_stat_gauge{group="a",name="b"}`.
+func gatherMetrics(t *testing.T, c prometheus.Collector) map[string]float64 {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(c); err != nil {
+		t.Fatalf("registering the collector: %v", err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gathering the metrics: %v", err)
+	}
+	metrics := make(map[string]float64)
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			var labels []string
+			for _, label := range m.GetLabel() {
+				labels = append(labels, fmt.Sprintf("%s=%q", label.GetName(), label.GetValue()))
+			}
+			sort.Strings(labels)
+			key := family.GetName()
+			if len(labels) > 0 {
+				key += "{" + strings.Join(labels, ",") + "}"
+			}
+			switch {
+			case m.GetGauge() != nil:
+				metrics[key] = m.GetGauge().GetValue()
+			case m.GetCounter() != nil:
+				metrics[key] = m.GetCounter().GetValue()
+			case m.GetUntyped() != nil:
+				metrics[key] = m.GetUntyped().GetValue()
+			}
+		}
+	}
+	return metrics
+}
+
+// updateMetrics runs a single collector against the client and returns its metrics like gatherMetrics.
+func updateMetrics(t *testing.T, c Collector, client Client) map[string]float64 {
+	t.Helper()
+	var updateErr error
+	metrics := gatherMetrics(t, collectorFunc(func(ch chan<- prometheus.Metric) {
+		updateErr = c.Update(context.Background(), client, ch)
+	}))
+	if updateErr != nil {
+		t.Fatalf("updating the collector: %v", updateErr)
+	}
+	return metrics
+}
+
+// collectorFunc is an unchecked prometheus.Collector sending the metrics of a function.
+type collectorFunc func(ch chan<- prometheus.Metric)
+
+func (f collectorFunc) Describe(chan<- *prometheus.Desc) {}
+
+func (f collectorFunc) Collect(ch chan<- prometheus.Metric) {
+	f(ch)
+}
+
+func TestScriptedMetricsReservedNames(t *testing.T) {
+	server := binrpctest.NewServer()
+	defer server.Close()
+	server.Reply("stats.fetch", binrpctest.Struct{
+		{Name: "script.calls_total", Value: "4"},
+		{Name: "script.up", Value: "1"},
+		{Name: "script.shm_bytes__type__x", Value: "2"},
+		{Name: "script.stat_gauge__group__a__name__b", Value: "3"},
+		{Name: "script.exporter_build_info", Value: "1"},
+		{Name: "script.scrape_collector_success__collector__x", Value: "1"},
+	})
+
+	c, err := NewKamailioCollector(testConfig(server.URI, "stats.fetch"), log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	metrics := gatherMetrics(t, c)
+	for key, want := range map[string]float64{
+		"kamailio_up":                       1,
+		"kamailio_calls_total":              4,
+		"kamailio_scripted_metrics_invalid": 5,
+		`kamailio_scrape_collector_success{collector="stats.fetch"}`: 1,
+	} {
+		if got, ok := metrics[key]; !ok || got != want {
+			t.Errorf("%s = %v (found: %v), want %v", key, got, ok, want)
+		}
+	}
+	if _, ok := metrics[`kamailio_shm_bytes{type="x"}`]; ok {
+		t.Errorf("scripted stat exported as kamailio_shm_bytes")
+	}
+}
+
+func TestDispatcherListCollector(t *testing.T) {
+	server := binrpctest.NewServer()
+	defer server.Close()
+	server.Reply("dispatcher.list", binrpctest.Struct{
+		{Name: "NRSETS", Value: 1},
+		{Name: "RECORDS", Value: binrpctest.Struct{
+			{Name: "SET", Value: binrpctest.Struct{
+				{Name: "ID", Value: 100},
+				{Name: "TARGETS", Value: binrpctest.Struct{
+					{Name: "DEST", Value: binrpctest.Struct{
+						{Name: "URI", Value: "sip:10.0.0.1"},
+						{Name: "FLAGS", Value: "AP"},
+						{Name: "PRIORITY", Value: 5},
+						{Name: "ATTRS", Value: binrpctest.Struct{
+							{Name: "WEIGHT", Value: 50},
+							{Name: "RWEIGHT", Value: 20},
+						}},
+						{Name: "LATENCY", Value: binrpctest.Struct{
+							{Name: "AVG", Value: 12.5},
+							{Name: "MAX", Value: 40},
+						}},
+					}},
+					{Name: "DEST", Value: binrpctest.Struct{
+						{Name: "URI", Value: "sip:10.0.0.2"},
+						{Name: "FLAGS", Value: "IX"},
+						{Name: "PRIORITY", Value: 0},
+					}},
+				}},
+			}},
+		}},
+	})
+
+	config := testConfig(server.URI, "dispatcher.list")
+	config.DispatcherMap = map[int]string{100: "carriers"}
+	c, err := NewDispatcherListCollector(config, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := Dial(config, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	metrics := updateMetrics(t, c, conn)
+	active := `destination="sip:10.0.0.1",set_id="100",set_name="carriers"`
+	inactive := `destination="sip:10.0.0.2",set_id="100",set_name="carriers"`
+	for _, want := range []struct {
+		key   string
+		value float64
+	}{
+		{"kamailio_dispatcher_list_target{" + active + "}", 1},
+		{"kamailio_dispatcher_list_target{" + inactive + "}", 0},
+		{"kamailio_dispatcher_list_target_state{" + active + `,state="active"}`, 1},
+		{"kamailio_dispatcher_list_target_state{" + inactive + `,state="inactive"}`, 1},
+		{"kamailio_dispatcher_list_target_state{" + inactive + `,state="active"}`, 0},
+		{"kamailio_dispatcher_list_target_state{" + inactive + `,state="unknown"}`, 0},
+		{`kamailio_dispatcher_list_target_probing{destination="sip:10.0.0.1",probing="true",set_id="100",set_name="carriers"}`, 1},
+		{`kamailio_dispatcher_list_target_probing{destination="sip:10.0.0.2",probing="false",set_id="100",set_name="carriers"}`, 1},
+		{"kamailio_dispatcher_list_target_priority{" + active + "}", 5},
+		{"kamailio_dispatcher_list_target_weight{" + active + "}", 50},
+		{"kamailio_dispatcher_list_target_rweight{" + active + "}", 20},
+		{"kamailio_dispatcher_list_target_latency_avg{" + active + "}", 12.5},
+		{"kamailio_dispatcher_list_target_latency_max{" + active + "}", 40},
+	} {
+		if got, ok := metrics[want.key]; !ok || got != want.value {
+			t.Errorf("%s = %v (found: %v), want %v", want.key, got, ok, want.value)
+		}
+	}
+}
+
+// openMetricsTypes gathers the collector and returns the TYPE lines of its OpenMetrics exposition,
+// failing the test when a metric family is declared twice.
+func openMetricsTypes(t *testing.T, c prometheus.Collector) map[string]string {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(c); err != nil {
+		t.Fatalf("registering the collector: %v", err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gathering the metrics: %v", err)
+	}
+	var out bytes.Buffer
+	encoder := expfmt.NewEncoder(&out, expfmt.FmtOpenMetrics_1_0_0)
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			t.Fatalf("encoding %s: %v", family.GetName(), err)
+		}
+	}
+	types := make(map[string]string)
+	for _, line := range strings.Split(out.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 4 || fields[1] != "TYPE" {
+			continue
+		}
+		if previous, ok := types[fields[2]]; ok {
+			t.Errorf("metric family %s declared as %s and %s", fields[2], previous, fields[3])
+		}
+		types[fields[2]] = fields[3]
+	}
+	return types
+}
+
+func TestMetricsSchemaOpenMetrics(t *testing.T) {
+	server := binrpctest.NewServer()
+	defer server.Close()
+	server.Reply("stats.fetch", binrpctest.Struct{
+		{Name: "dialog.active_dialogs", Value: "2"},
+		{Name: "dialog.early_dialogs", Value: "1"},
+		{Name: "dialog.processed_dialogs", Value: "9"},
+		{Name: "dialog.expired_dialogs", Value: "1"},
+		{Name: "dialog.failed_dialogs", Value: "3"},
+		{Name: "usrloc.registered_users", Value: "5"},
+		{Name: "registrar.accepted_regs", Value: "42"},
+	})
+	server.Reply("dlg.stats_active", binrpctest.Struct{
+		{Name: "starting", Value: 1},
+		{Name: "connecting", Value: 0},
+		{Name: "answering", Value: 0},
+		{Name: "ongoing", Value: 2},
+		{Name: "all", Value: 3},
+	})
+
+	for _, schema := range MetricsSchemas {
+		config := testConfig(server.URI, "stats.fetch", "dlg.stats_active")
+		config.MetricsSchema = &schema
+		config.StatTypes = []StatTypeRule{{Match: "registrar.*_regs", Type: "counter"}}
+		c, err := NewKamailioCollector(config, log.NewNopLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		types := openMetricsTypes(t, c)
+		c.Close()
+
+		if types["kamailio_stat_gauge"] != "gauge" || types["kamailio_stat_counter"] != "counter" {
+			t.Errorf("%s: got generic stat families %v", schema, types)
+		}
+
+		if schema == "v1" {
+			continue
+		}
+		for name, want := range map[string]string{
+			"kamailio_dialogs_active":     "gauge",
+			"kamailio_dialogs_ended":      "counter",
+			"kamailio_dlg_active_dialogs": "gauge",
+		} {
+			if got := types[name]; got != want {
+				t.Errorf("%s: %s is a %q, want a %s", schema, name, got, want)
+			}
+		}
+		if _, ok := types["kamailio_dlg_active_dialogs_all"]; ok {
+			t.Errorf("%s: kamailio_dlg_active_dialogs_all is exported", schema)
+		}
+	}
+}
+
+func TestMinScrapeIntervalOverlappingCollectors(t *testing.T) {
+	server := binrpctest.NewServer()
+	defer server.Close()
+	server.Reply("stats.fetch", binrpctest.Struct{{Name: "core.rcv_requests", Value: "10"}})
+	server.Reply("dlg.stats_active", binrpctest.Struct{{Name: "all", Value: 3}})
+	server.Reply("tm.stats", binrpctest.Struct{{Name: "current", Value: 1}})
+
+	config := testConfig(server.URI, "stats.fetch", "dlg.stats_active", "tm.stats")
+	minInterval := time.Minute
+	config.MinScrapeInterval = &minInterval
+	c, err := NewKamailioCollector(config, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// two jobs selecting overlapping collectors
+	for _, include := range [][]string{{"stats.fetch", "dlg.stats_active"}, {"dlg.stats_active", "tm.stats"}} {
+		job, err := c.Filter(include, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		metrics := gatherMetrics(t, job)
+		for _, name := range include {
+			key := fmt.Sprintf("kamailio_scrape_collector_success{collector=%q}", name)
+			if metrics[key] != 1 {
+				t.Errorf("%v: %s = %v, want 1", include, key, metrics[key])
+			}
+		}
+	}
+
+	calls := make(map[string]int)
+	for _, call := range server.Calls() {
+		calls[call[0]]++
+	}
+	for method, want := range map[string]int{"system.listMethods": 2, "stats.fetch": 1, "dlg.stats_active": 1, "tm.stats": 1} {
+		if calls[method] != want {
+			t.Errorf("%s called %d times, want %d", method, calls[method], want)
+		}
+	}
+}
+
+func TestNewKamailioCollectorDefaults(t *testing.T) {
+	server := binrpctest.NewServer()
+	defer server.Close()
+	server.Reply("dlg.stats_active", binrpctest.Struct{{Name: "all", Value: 3}})
+
+	// a configuration built by hand may leave out the optional settings
+	uri := server.URI
+	timeout := 5 * time.Second
+	interval := time.Hour
+	for _, config := range []*KamailioCollectorConfig{
+		{RPCURI: &uri, Timeout: &timeout, Collectors: map[string]bool{"dlg.stats_active": true}},
+		{RPCURI: &uri, Timeout: &timeout, Collectors: map[string]bool{"dlg.stats_active": true}, ScrapeInterval: &interval},
+	} {
+		c, err := NewKamailioCollector(config, log.NewNopLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.ScrapeInterval == nil {
+			if got := gatherMetrics(t, c)["kamailio_up"]; got != 1 {
+				t.Errorf("kamailio_up = %v, want 1", got)
+			}
+		}
+		c.Close()
+	}
+}
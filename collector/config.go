@@ -22,18 +22,75 @@
 package collector
 
 import (
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 )
 
+// MetricsSchemas are the versions of the metric names and types: "v1" keeps the original ones,
+// "v2" corrects them and "compat" emits both during a migration.
+var MetricsSchemas = []string{"v1", "v2", "compat"}
+
 type KamailioCollectorConfig struct {
 	DialogProfile DialogConfig
 	DispatcherMap map[int]string
 
-	BinrpcURI  *string
-	Timeout    *time.Duration
-	Collectors map[string]bool
+	RPCURI            *string
+	Timeout           *time.Duration
+	MaxConnections    *int
+	ScrapeInterval    *time.Duration
+	ScrapeMaxAge      *time.Duration
+	MinScrapeInterval *time.Duration
+	CollectorTimeouts map[string]time.Duration
+	Collectors        map[string]bool
+	// MetricsSchema is one of MetricsSchemas, "v1" when empty.
+	MetricsSchema *string
+	// StatGroups are the stats.fetch groups fetched one by one, all the statistics are fetched at once when empty.
+	StatGroups *[]string
+	// StatTypes gives the type of the generic stats.fetch metrics, before the default rules.
+	StatTypes []StatTypeRule
+	// ScriptedMetrics describes the scripted metrics, the first matching rule applies.
+	ScriptedMetrics []ScriptedMetricRule
+	// RPCCollectors are the collectors declared in the configuration file.
+	RPCCollectors []RPCCollectorConfig
+
+	// ReplyDir is the directory of the reply FIFOs and sockets of the fifo:// and unixgram:// transports.
+	ReplyDir *string
+	// RecordDir is the directory in which the RPC replies are recorded.
+	RecordDir *string
+	// ReplayDir is the directory of recorded RPC replies served instead of scraping Kamailio.
+	ReplayDir *string
 }
 
 type DialogConfig struct {
 	Profiles *[]string
 }
+
+// ValidateMetricsSchema returns an error if the schema is not one of MetricsSchemas.
+func ValidateMetricsSchema(schema string) error {
+	if slices.Contains(MetricsSchemas, schema) {
+		return nil
+	}
+	return fmt.Errorf("unknown metrics schema %q, expected v1, v2 or compat", schema)
+}
+
+// ValidateStatGroups returns an error if one of the groups is empty or is not a single stats.fetch group,
+// which has no ".", ":" or whitespace.
+func ValidateStatGroups(groups ...string) error {
+	for _, group := range groups {
+		if group == "" || strings.ContainsAny(group, ".: \t") {
+			return fmt.Errorf("invalid stat group %q", group)
+		}
+	}
+	return nil
+}
+
+// schemaVersions reports whether the metrics of the v1 schema and of the v2 schema are emitted.
+func schemaVersions(config *KamailioCollectorConfig) (v1 bool, v2 bool) {
+	schema := "v1"
+	if config.MetricsSchema != nil && *config.MetricsSchema != "" {
+		schema = *config.MetricsSchema
+	}
+	return schema != "v2", schema != "v1"
+}
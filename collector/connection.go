@@ -0,0 +1,181 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/jpillora/backoff"
+)
+
+// errConnectionClosed is returned once the collector has been closed.
+var errConnectionClosed = errors.New("connection closed")
+
+// connectionPool holds long-lived transports to Kamailio, shared by the scrapes.
+// At most maxConns connections are used at the same time. A broken connection is dropped
+// and dialed again when needed, with an exponential backoff after failed attempts.
+type connectionPool struct {
+	dial   func(context.Context) (transport, error)
+	logger log.Logger
+	slots  chan struct{}
+
+	mtx     sync.Mutex
+	idle    []transport
+	backoff backoff.Backoff
+	retryAt time.Time
+	lost    int
+	closed  bool
+
+	dialFailures float64
+	reconnects   float64
+}
+
+func newConnectionPool(dial func(context.Context) (transport, error), maxConns int, logger log.Logger) *connectionPool {
+	return &connectionPool{
+		dial:    dial,
+		logger:  logger,
+		slots:   make(chan struct{}, max(maxConns, 1)),
+		backoff: backoff.Backoff{Min: 500 * time.Millisecond, Max: 30 * time.Second, Factor: 2, Jitter: true},
+	}
+}
+
+// acquire returns a connection for the exclusive use of the caller, dialing Kamailio if no idle one is left.
+// It waits for a connection slot until the context is done, and the slot is given back if no connection
+// can be had. The connection must be given back with release.
+func (p *connectionPool) acquire(ctx context.Context) (transport, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for a free connection: %w", ctx.Err())
+	}
+
+	conn, err := p.get(ctx)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return conn, nil
+}
+
+// get pops a healthy idle connection, or dials a new one in the slot reserved by acquire.
+// The pool is not locked while dialing, so a slow Kamailio only holds up the scrapes waiting for it.
+func (p *connectionPool) get(ctx context.Context) (transport, error) {
+	for {
+		p.mtx.Lock()
+		if p.closed {
+			p.mtx.Unlock()
+			return nil, errConnectionClosed
+		}
+		if len(p.idle) == 0 {
+			break
+		}
+		conn := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mtx.Unlock()
+
+		if conn.healthy() {
+			return conn, nil
+		}
+		level.Debug(p.logger).Log("msg", "Dropping broken connection to kamailio")
+		p.drop(conn)
+	}
+	wait := time.Until(p.retryAt)
+	p.mtx.Unlock()
+	if wait > 0 {
+		return nil, fmt.Errorf("waiting %s before reconnecting", wait.Round(time.Millisecond))
+	}
+
+	conn, err := p.dial(ctx)
+	// a dial ended by the scrape tells nothing about Kamailio
+	if err != nil && ctx.Err() != nil {
+		return nil, err
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if err != nil {
+		p.dialFailures++
+		p.retryAt = time.Now().Add(p.backoff.Duration())
+		return nil, err
+	}
+	if p.closed {
+		conn.Close()
+		return nil, errConnectionClosed
+	}
+	if p.lost > 0 {
+		p.lost--
+		p.reconnects++
+	}
+	p.backoff.Reset()
+	return conn, nil
+}
+
+// release gives a connection back to the pool, or drops it if it was broken while in use.
+func (p *connectionPool) release(conn transport) {
+	defer func() { <-p.slots }()
+
+	if conn.broken() {
+		p.drop(conn)
+		return
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.closed {
+		conn.Close()
+		return
+	}
+	p.idle = append(p.idle, conn)
+}
+
+func (p *connectionPool) drop(conn transport) {
+	conn.Close()
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.lost++
+}
+
+// stats returns the dial failures and reconnects counters.
+func (p *connectionPool) stats() (float64, float64) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.dialFailures, p.reconnects
+}
+
+// Close closes the idle connections. The connections still in use are closed when they are released.
+func (p *connectionPool) Close() error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.closed = true
+	var errs []error
+	for _, conn := range p.idle {
+		errs = append(errs, conn.Close())
+	}
+	p.idle = nil
+	return errors.Join(errs...)
+}
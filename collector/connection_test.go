@@ -0,0 +1,91 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package collector
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+type idleTransport struct{}
+
+func (idleTransport) Call(context.Context, string, ...string) ([]Record, error) { return nil, nil }
+func (idleTransport) broken() bool                                              { return false }
+func (idleTransport) healthy() bool                                             { return true }
+func (idleTransport) Close() error                                              { return nil }
+
+func TestConnectionPoolDialOutsideLock(t *testing.T) {
+	dialing := make(chan struct{})
+	pool := newConnectionPool(func(ctx context.Context) (transport, error) {
+		close(dialing)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}, 2, log.NewNopLogger())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dialed := make(chan error, 1)
+	go func() {
+		_, err := pool.acquire(ctx)
+		dialed <- err
+	}()
+	<-dialing
+
+	// A connection given back while the dial is pending is handed out right away.
+	pool.slots <- struct{}{}
+	pool.release(idleTransport{})
+	acquired := make(chan error, 1)
+	go func() {
+		conn, err := pool.acquire(context.Background())
+		if err == nil {
+			pool.release(conn)
+		}
+		acquired <- err
+	}()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("acquire idle connection: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("acquire blocked by a pending dial")
+	}
+
+	// Cancelling the context aborts the dial and gives its slot back.
+	cancel()
+	if err := <-dialed; !errors.Is(err, context.Canceled) {
+		t.Fatalf("got dial error %v, want %v", err, context.Canceled)
+	}
+	if n := len(pool.slots); n != 0 {
+		t.Errorf("got %d slots in use, want 0", n)
+	}
+	// the canceled dial is neither a failure nor a reason to back off
+	if failures, _ := pool.stats(); failures != 0 {
+		t.Errorf("got %v dial failures, want 0", failures)
+	}
+	if !pool.retryAt.IsZero() {
+		t.Errorf("got a reconnection backoff until %s after a canceled dial", pool.retryAt)
+	}
+}
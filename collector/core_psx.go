@@ -22,7 +22,7 @@
 package collector
 
 import (
-	"net"
+	"context"
 	"strconv"
 
 	"github.com/go-kit/log"
@@ -51,8 +51,12 @@ func NewCorePsaCollector(config *KamailioCollectorConfig, logger log.Logger) (Co
 	}, nil
 }
 
-func (c *CorePsxCollector) Update(conn net.Conn, metricChannel chan<- prometheus.Metric) error {
-	records, err := getRecords(conn, c.logger, "core.psa")
+func (c *CorePsxCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.coreProcessStatus
+}
+
+func (c *CorePsxCollector) Update(ctx context.Context, client Client, metricChannel chan<- prometheus.Metric) error {
+	records, err := getRecords(ctx, client, c.logger, "core.psa")
 	if err != nil {
 		return err
 	}
@@ -22,7 +22,7 @@
 package collector
 
 import (
-	"net"
+	"context"
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
@@ -50,8 +50,12 @@ func NewCoreRuninfoCollector(config *KamailioCollectorConfig, logger log.Logger)
 	}, nil
 }
 
-func (c *CoreRuninfoCollector) Update(conn net.Conn, metricChannel chan<- prometheus.Metric) error {
-	records, err := getRecords(conn, c.logger, "core.runinfo")
+func (c *CoreRuninfoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.coreUptime
+}
+
+func (c *CoreRuninfoCollector) Update(ctx context.Context, client Client, metricChannel chan<- prometheus.Metric) error {
+	records, err := getRecords(ctx, client, c.logger, "core.runinfo")
 	if err != nil {
 		return err
 	}
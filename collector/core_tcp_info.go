@@ -22,7 +22,7 @@
 package collector
 
 import (
-	"net"
+	"context"
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
@@ -65,9 +65,16 @@ func NewCoreTCPInfoCollector(config *KamailioCollectorConfig, logger log.Logger)
 	}, nil
 }
 
-func (c *coreTCPInfoCollector) Update(conn net.Conn, metricChannel chan<- prometheus.Metric) error {
+func (c *coreTCPInfoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.tcpReaders
+	ch <- c.tcpMaxConnections
+	ch <- c.tlsMaxConnections
+	ch <- c.tlsConnections
+}
+
+func (c *coreTCPInfoCollector) Update(ctx context.Context, client Client, metricChannel chan<- prometheus.Metric) error {
 	// fetch tcp details
-	records, err := getRecords(conn, c.logger, "core.tcp_info")
+	records, err := getRecords(ctx, client, c.logger, "core.tcp_info")
 	if err != nil {
 		return err
 	}
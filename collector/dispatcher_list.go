@@ -22,22 +22,33 @@
 package collector
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"net"
 	"strconv"
 	"strings"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
-	"go.voiplens.io/kamailio/binrpc"
 )
 
 func init() {
 	registerCollector("dispatcher.list", defaultEnabled, NewDispatcherListCollector)
 }
 
+// dispatcherStates are the states of a target, given by the first character of its flags.
+var dispatcherStates = map[byte]string{
+	'A': "active",
+	'I': "inactive",
+	'D': "disabled",
+	'T': "trying",
+}
+
+// dispatcherUnknownState is the state of a target whose flags are empty or start with an unknown character,
+// so that a target always has one of the states.
+const dispatcherUnknownState = "unknown"
+
 // DispatcherTarget is a target of the dispatcher module.
 type DispatcherTarget struct {
 	ID             int
@@ -45,6 +56,8 @@ type DispatcherTarget struct {
 	Flags          string
 	Priority       int
 	Status         float64
+	State          string
+	Probing        bool
 	Body           string
 	Weight         int
 	RWeight        int
@@ -61,6 +74,8 @@ type dispatcherListCollector struct {
 	logger         log.Logger
 	target         *prometheus.Desc
 	targetFlags    *prometheus.Desc
+	targetState    *prometheus.Desc
+	targetProbing  *prometheus.Desc
 	latencyAvg     *prometheus.Desc
 	latencyStd     *prometheus.Desc
 	latencyEst     *prometheus.Desc
@@ -79,6 +94,8 @@ func NewDispatcherListCollector(config *KamailioCollectorConfig, logger log.Logg
 		logger:         logger,
 		target:         prometheus.NewDesc(prometheus.BuildFQName(namespace, "dispatcher_list", "target"), "Target status.", []string{"set_id", "destination", "set_name"}, nil),
 		targetFlags:    prometheus.NewDesc(prometheus.BuildFQName(namespace, "dispatcher_list", "target_flags_status"), "Target flags.", []string{"set_id", "destination", "set_name", "flags"}, nil),
+		targetState:    prometheus.NewDesc(prometheus.BuildFQName(namespace, "dispatcher_list", "target_state"), "Target state, 1 for the current one of active, inactive, disabled, trying or unknown.", []string{"set_id", "destination", "set_name", "state"}, nil),
+		targetProbing:  prometheus.NewDesc(prometheus.BuildFQName(namespace, "dispatcher_list", "target_probing"), "Whether the target is probed, 1 for the current value.", []string{"set_id", "destination", "set_name", "probing"}, nil),
 		latencyAvg:     prometheus.NewDesc(prometheus.BuildFQName(namespace, "dispatcher_list", "target_latency_avg"), "Target Latency Average.", []string{"set_id", "destination", "set_name"}, nil),
 		latencyStd:     prometheus.NewDesc(prometheus.BuildFQName(namespace, "dispatcher_list", "target_latency_std"), "Target Latency.", []string{"set_id", "destination", "set_name"}, nil),
 		latencyEst:     prometheus.NewDesc(prometheus.BuildFQName(namespace, "dispatcher_list", "target_latency_est"), "Target Latency.", []string{"set_id", "destination", "set_name"}, nil),
@@ -90,8 +107,23 @@ func NewDispatcherListCollector(config *KamailioCollectorConfig, logger log.Logg
 	}, nil
 }
 
-func (c *dispatcherListCollector) Update(conn net.Conn, metricChannel chan<- prometheus.Metric) error {
-	records, err := getRecords(conn, c.logger, "dispatcher.list")
+func (c *dispatcherListCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.target
+	ch <- c.targetFlags
+	ch <- c.targetState
+	ch <- c.targetProbing
+	ch <- c.latencyAvg
+	ch <- c.latencyStd
+	ch <- c.latencyEst
+	ch <- c.latencyMax
+	ch <- c.latencyTimeout
+	ch <- c.weight
+	ch <- c.rweight
+	ch <- c.priority
+}
+
+func (c *dispatcherListCollector) Update(ctx context.Context, client Client, metricChannel chan<- prometheus.Metric) error {
+	records, err := getRecords(ctx, client, c.logger, "dispatcher.list")
 	if err != nil {
 		return err
 	}
@@ -107,6 +139,12 @@ func (c *dispatcherListCollector) Update(conn net.Conn, metricChannel chan<- pro
 		setName := c.config.DispatcherMap[target.ID]
 		metricChannel <- prometheus.MustNewConstMetric(c.target, prometheus.GaugeValue, target.Status, setID, target.URI, setName)
 		metricChannel <- prometheus.MustNewConstMetric(c.targetFlags, prometheus.GaugeValue, 1, setID, target.URI, setName, target.Flags)
+		for _, state := range dispatcherStates {
+			metricChannel <- prometheus.MustNewConstMetric(c.targetState, prometheus.GaugeValue, boolToFloat(target.State == state), setID, target.URI, setName, state)
+		}
+		metricChannel <- prometheus.MustNewConstMetric(c.targetState, prometheus.GaugeValue, boolToFloat(target.State == dispatcherUnknownState), setID, target.URI, setName, dispatcherUnknownState)
+		metricChannel <- prometheus.MustNewConstMetric(c.targetProbing, prometheus.GaugeValue, boolToFloat(target.Probing), setID, target.URI, setName, "true")
+		metricChannel <- prometheus.MustNewConstMetric(c.targetProbing, prometheus.GaugeValue, boolToFloat(!target.Probing), setID, target.URI, setName, "false")
 		metricChannel <- prometheus.MustNewConstMetric(c.latencyAvg, prometheus.GaugeValue, target.LatencyAvg, setID, target.URI, setName)
 		metricChannel <- prometheus.MustNewConstMetric(c.latencyStd, prometheus.GaugeValue, target.LatencyStd, setID, target.URI, setName)
 		metricChannel <- prometheus.MustNewConstMetric(c.latencyEst, prometheus.GaugeValue, target.LatencyEst, setID, target.URI, setName)
@@ -120,7 +158,7 @@ func (c *dispatcherListCollector) Update(conn net.Conn, metricChannel chan<- pro
 }
 
 // parseDispatcherTargets parses the "dispatcher.list" result and returns a list of targets.
-func parseDispatcherTargets(records []binrpc.Record) ([]DispatcherTarget, error) {
+func parseDispatcherTargets(records []Record) ([]DispatcherTarget, error) {
 	var targets []DispatcherTarget
 	for _, record := range records {
 		items, _ := record.StructItems()
@@ -134,7 +172,7 @@ func parseDispatcherTargets(records []binrpc.Record) ([]DispatcherTarget, error)
 	return targets, nil
 }
 
-func parseRecords(items []binrpc.StructItem) ([]DispatcherTarget, error) {
+func parseRecords(items []StructItem) ([]DispatcherTarget, error) {
 	var targets []DispatcherTarget
 	for _, item := range items {
 		if item.Key != "RECORDS" {
@@ -166,9 +204,9 @@ func parseRecords(items []binrpc.StructItem) ([]DispatcherTarget, error) {
 	return targets, nil
 }
 
-func parseSetItems(setItems []binrpc.StructItem) ([]DispatcherTarget, error) {
+func parseSetItems(setItems []StructItem) ([]DispatcherTarget, error) {
 	var setID int
-	var destinations []binrpc.StructItem
+	var destinations []StructItem
 	var err error
 
 	for _, set := range setItems {
@@ -196,7 +234,7 @@ func parseSetItems(setItems []binrpc.StructItem) ([]DispatcherTarget, error) {
 	return targets, nil
 }
 
-func parseDestinations(setID int, destinations []binrpc.StructItem) ([]DispatcherTarget, error) {
+func parseDestinations(setID int, destinations []StructItem) ([]DispatcherTarget, error) {
 	var targets []DispatcherTarget
 	for _, destination := range destinations {
 		if destination.Key != "DEST" {
@@ -223,7 +261,8 @@ func parseDestinations(setID int, destinations []binrpc.StructItem) ([]Dispatche
 				if err != nil {
 					return nil, err
 				}
-				if target.Flags == "AP" {
+				target.State, target.Probing = parseDestinationFlags(target.Flags)
+				if target.State == "active" {
 					target.Status = 1
 				}
 			case "PRIORITY":
@@ -232,12 +271,12 @@ func parseDestinations(setID int, destinations []binrpc.StructItem) ([]Dispatche
 					return nil, err
 				}
 			case "ATTRS":
-				err := parseDestinationAttributes(prop, target)
+				err := parseDestinationAttributes(prop, &target)
 				if err != nil {
 					return nil, err
 				}
 			case "LATENCY":
-				err := parseDestinationLatency(prop, target)
+				err := parseDestinationLatency(prop, &target)
 				if err != nil {
 					return nil, err
 				}
@@ -249,7 +288,21 @@ func parseDestinations(setID int, destinations []binrpc.StructItem) ([]Dispatche
 	return targets, nil
 }
 
-func parseDestinationLatency(prop binrpc.StructItem, target DispatcherTarget) error {
+// parseDestinationFlags returns the state of a target, given by the first character of its flags,
+// e.g. "AP" or "DX", and whether it is probed, given by a "P" in the other characters.
+// The state is unknown when the flags are empty or start with another character.
+func parseDestinationFlags(flags string) (string, bool) {
+	if flags == "" {
+		return dispatcherUnknownState, false
+	}
+	state, ok := dispatcherStates[flags[0]]
+	if !ok {
+		state = dispatcherUnknownState
+	}
+	return state, strings.Contains(flags[1:], "P")
+}
+
+func parseDestinationLatency(prop StructItem, target *DispatcherTarget) error {
 	latency, err := prop.Value.StructItems()
 	if err != nil {
 		return err
@@ -271,7 +324,7 @@ func parseDestinationLatency(prop binrpc.StructItem, target DispatcherTarget) er
 	return nil
 }
 
-func parseDestinationAttributes(prop binrpc.StructItem, target DispatcherTarget) error {
+func parseDestinationAttributes(prop StructItem, target *DispatcherTarget) error {
 	attrs, err := prop.Value.StructItems()
 	if err != nil {
 		return err
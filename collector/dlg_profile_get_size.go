@@ -22,7 +22,7 @@
 package collector
 
 import (
-	"net"
+	"context"
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
@@ -47,9 +47,13 @@ func NewDlgProfileCollector(config *KamailioCollectorConfig, logger log.Logger)
 	}, nil
 }
 
-func (c *dlgProfileCollector) Update(conn net.Conn, metricChannel chan<- prometheus.Metric) error {
+func (c *dlgProfileCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.dialog
+}
+
+func (c *dlgProfileCollector) Update(ctx context.Context, client Client, metricChannel chan<- prometheus.Metric) error {
 	for _, p := range *c.config.DialogProfile.Profiles {
-		records, err := getRecords(conn, c.logger, "dlg.profile_get_size", p)
+		records, err := getRecords(ctx, client, c.logger, "dlg.profile_get_size", p)
 		if err != nil {
 			return err
 		}
@@ -22,7 +22,7 @@
 package collector
 
 import (
-	"net"
+	"context"
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
@@ -35,7 +35,11 @@ func init() {
 type dlgStatsActiveCollector struct {
 	logger log.Logger
 	gauges map[string]*prometheus.Desc
-	config *KamailioCollectorConfig
+	// the metrics of the v2 schema
+	dialogs  *prometheus.Desc
+	schemaV1 bool
+	schemaV2 bool
+	config   *KamailioCollectorConfig
 }
 
 // NewCoreStatsCollector returns a new Collector exposing core stats.
@@ -47,15 +51,30 @@ func NewDlgStatsActiveCollector(config *KamailioCollectorConfig, logger log.Logg
 		"ongoing":    prometheus.NewDesc(prometheus.BuildFQName(namespace, "dlg_stats_active", "ongoing"), "Dialog ongoing.", []string{}, nil),
 		"all":        prometheus.NewDesc(prometheus.BuildFQName(namespace, "dlg_stats_active", "all"), "Dialog all.", []string{}, nil),
 	}
+	schemaV1, schemaV2 := schemaVersions(config)
 	return &dlgStatsActiveCollector{
-		gauges: gauges,
-		config: config,
-		logger: logger,
+		gauges:   gauges,
+		dialogs:  prometheus.NewDesc(prometheus.BuildFQName(namespace, "dlg", "active_dialogs"), "Active dialogs by state.", []string{"state"}, nil),
+		schemaV1: schemaV1,
+		schemaV2: schemaV2,
+		config:   config,
+		logger:   logger,
 	}, nil
 }
 
-func (c *dlgStatsActiveCollector) Update(conn net.Conn, metricChannel chan<- prometheus.Metric) error {
-	records, err := getRecords(conn, c.logger, "dlg.stats_active")
+func (c *dlgStatsActiveCollector) Describe(ch chan<- *prometheus.Desc) {
+	if c.schemaV1 {
+		for _, desc := range c.gauges {
+			ch <- desc
+		}
+	}
+	if c.schemaV2 {
+		ch <- c.dialogs
+	}
+}
+
+func (c *dlgStatsActiveCollector) Update(ctx context.Context, client Client, metricChannel chan<- prometheus.Metric) error {
+	records, err := getRecords(ctx, client, c.logger, "dlg.stats_active")
 	if err != nil {
 		return err
 	}
@@ -65,9 +84,17 @@ func (c *dlgStatsActiveCollector) Update(conn net.Conn, metricChannel chan<- pro
 		items, _ := record.StructItems()
 		for _, item := range items {
 			i, _ := item.Value.Int()
-			if desc, ok := c.gauges[item.Key]; ok {
+			desc, ok := c.gauges[item.Key]
+			if !ok {
+				continue
+			}
+			if c.schemaV1 {
 				metricChannel <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(i))
 			}
+			// the v2 schema has no total, which is the sum of the states
+			if c.schemaV2 && item.Key != "all" {
+				metricChannel <- prometheus.MustNewConstMetric(c.dialogs, prometheus.GaugeValue, float64(i), item.Key)
+			}
 		}
 	}
 	return nil
@@ -0,0 +1,116 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scrapeGroup coalesces the concurrent scrapes of a collector into a single RPC call, whatever the other
+// collectors of the scrapes, and serves the results of the last call to the scrapes starting less than
+// minInterval after it.
+type scrapeGroup struct {
+	minInterval time.Duration
+
+	mtx sync.Mutex
+	// rounds holds the last round of each collector, and the last round of all under commonKey.
+	rounds map[string]*scrapeRound
+}
+
+// commonKey is the key of the metrics which do not belong to a collector, such as kamailio_up,
+// which every round produces.
+const commonKey = ""
+
+// scrapeRound holds the results of a scrape shared by concurrent requests.
+type scrapeRound struct {
+	done     chan struct{}
+	finished time.Time
+	common   []prometheus.Metric
+	results  []scrapeResult
+}
+
+func newScrapeGroup(minInterval time.Duration) *scrapeGroup {
+	return &scrapeGroup{minInterval: minInterval, rounds: make(map[string]*scrapeRound)}
+}
+
+// do returns the metrics which do not belong to a collector and the results of the named collectors.
+// A collector already run by a round in progress, or by a recent one, gets the results of that round,
+// and scrape runs the other collectors in a new round.
+func (g *scrapeGroup) do(names []string, scrape func(names []string) ([]prometheus.Metric, []scrapeResult)) ([]prometheus.Metric, []scrapeResult) {
+	g.mtx.Lock()
+	g.prune()
+	rounds := make(map[string]*scrapeRound, len(names)+1)
+	var missing []string
+	for _, name := range names {
+		if r, ok := g.rounds[name]; ok {
+			rounds[name] = r
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	rounds[commonKey] = g.rounds[commonKey]
+	var own *scrapeRound
+	if len(missing) > 0 || rounds[commonKey] == nil {
+		own = &scrapeRound{done: make(chan struct{})}
+		for _, name := range append([]string{commonKey}, missing...) {
+			g.rounds[name] = own
+			rounds[name] = own
+		}
+	}
+	g.mtx.Unlock()
+
+	if own != nil {
+		own.common, own.results = scrape(missing)
+		own.finished = time.Now()
+		close(own.done)
+	}
+
+	var results []scrapeResult
+	for _, name := range names {
+		r := rounds[name]
+		<-r.done
+		for _, result := range r.results {
+			if result.name == name {
+				results = append(results, result)
+			}
+		}
+	}
+	common := rounds[commonKey]
+	<-common.done
+	return common.common, results
+}
+
+// prune forgets the rounds which can not be reused anymore. It must be called with the lock held.
+func (g *scrapeGroup) prune() {
+	for key, r := range g.rounds {
+		select {
+		case <-r.done:
+			if time.Since(r.finished) >= g.minInterval {
+				delete(g.rounds, key)
+			}
+		default:
+		}
+	}
+}
@@ -22,7 +22,7 @@
 package collector
 
 import (
-	"net"
+	"context"
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
@@ -65,8 +65,15 @@ func NewHtableListTablesCollector(config *KamailioCollectorConfig, logger log.Lo
 	}, nil
 }
 
-func (c *HtableListTablesCollector) Update(conn net.Conn, metricChannel chan<- prometheus.Metric) error {
-	records, err := getRecords(conn, c.logger, "htable.listTables")
+func (c *HtableListTablesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.htableAutoExpire
+	ch <- c.htableUpdateExpire
+	ch <- c.htableDmqReplicate
+	ch <- c.htableDBMode
+}
+
+func (c *HtableListTablesCollector) Update(ctx context.Context, client Client, metricChannel chan<- prometheus.Metric) error {
+	records, err := getRecords(ctx, client, c.logger, "htable.listTables")
 	if err != nil {
 		return err
 	}
@@ -22,7 +22,7 @@
 package collector
 
 import (
-	"net"
+	"context"
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
@@ -65,8 +65,15 @@ func NewHtableStatsCollector(config *KamailioCollectorConfig, logger log.Logger)
 	}, nil
 }
 
-func (c *HtableStatsCollector) Update(conn net.Conn, metricChannel chan<- prometheus.Metric) error {
-	records, err := getRecords(conn, c.logger, "htable.stats")
+func (c *HtableStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.htableSlot
+	ch <- c.htableTotal
+	ch <- c.htableMin
+	ch <- c.htableMax
+}
+
+func (c *HtableStatsCollector) Update(ctx context.Context, client Client, metricChannel chan<- prometheus.Metric) error {
+	records, err := getRecords(ctx, client, c.logger, "htable.stats")
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,249 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// jsonrpcRequestID numbers the JSON-RPC requests of the exporter.
+var jsonrpcRequestID atomic.Uint64
+
+type jsonrpcRequest struct {
+	JSONRPC string   `json:"jsonrpc"`
+	Method  string   `json:"method"`
+	Params  []string `json:"params,omitempty"`
+	ID      uint64   `json:"id"`
+}
+
+type jsonrpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *jsonrpcError   `json:"error"`
+	ID     uint64          `json:"id"`
+}
+
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *jsonrpcError) Error() string {
+	return fmt.Sprintf("%d %s", e.Code, e.Message)
+}
+
+// httpTransport speaks JSON-RPC over HTTP to the jsonrpcs module, served by xhttp.
+type httpTransport struct {
+	client *http.Client
+	url    string
+}
+
+func newHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout}
+}
+
+// marshalJSONRPCRequest encodes the request running method and returns its ID.
+func marshalJSONRPCRequest(method string, args []string) ([]byte, uint64, error) {
+	id := jsonrpcRequestID.Add(1)
+	body, err := json.Marshal(jsonrpcRequest{JSONRPC: "2.0", Method: method, Params: args, ID: id})
+	return body, id, err
+}
+
+// unmarshalJSONRPCReply decodes the reply to the request with the given ID.
+func unmarshalJSONRPCReply(content []byte, id uint64) ([]Record, error) {
+	var reply jsonrpcResponse
+	if err := json.Unmarshal(content, &reply); err != nil {
+		return nil, fmt.Errorf("invalid JSON-RPC reply: %w", err)
+	}
+	if reply.Error != nil {
+		return nil, reply.Error
+	}
+	if reply.ID != id {
+		return nil, fmt.Errorf("JSON-RPC reply ID %d does not match request ID %d", reply.ID, id)
+	}
+	return decodeJSONRecords(reply.Result)
+}
+
+func (t *httpTransport) Call(ctx context.Context, method string, args ...string) ([]Record, error) {
+	body, id, err := marshalJSONRPCRequest(method, args)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// jsonrpcs replies to failed commands with an error object and a matching HTTP status
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	records, err := unmarshalJSONRPCReply(content, id)
+	var rpcErr *jsonrpcError
+	if errors.As(err, &rpcErr) {
+		return nil, err
+	}
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = errors.New("unexpected reply without an error")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("HTTP status %s: %w", resp.Status, err)
+	}
+	return records, nil
+}
+
+func (t *httpTransport) broken() bool {
+	return false
+}
+
+func (t *httpTransport) healthy() bool {
+	return true
+}
+
+func (t *httpTransport) Close() error {
+	return nil
+}
+
+// decodeJSONRecords converts the result of a JSON-RPC reply into the records BINRPC would return.
+// A command adding several values replies with an array, which holds one record per value.
+func decodeJSONRecords(result json.RawMessage) ([]Record, error) {
+	if len(result) == 0 {
+		return nil, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(result))
+	decoder.UseNumber()
+	if !bytes.HasPrefix(bytes.TrimSpace(result), []byte("[")) {
+		record, err := decodeJSONValue(decoder)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON-RPC result: %w", err)
+		}
+		return []Record{record}, nil
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("invalid JSON-RPC result: %w", err)
+	}
+	var records []Record
+	for decoder.More() {
+		record, err := decodeJSONValue(decoder)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON-RPC result: %w", err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// decodeJSONValue reads the next value of the decoder, keeping the order of the object members.
+func decodeJSONValue(decoder *json.Decoder) (Record, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return Record{}, err
+	}
+
+	switch v := token.(type) {
+	case json.Delim:
+		switch v {
+		case '{':
+			var items []StructItem
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return Record{}, err
+				}
+				value, err := decodeJSONValue(decoder)
+				if err != nil {
+					return Record{}, err
+				}
+				items = append(items, StructItem{Key: key.(string), Value: value})
+			}
+			_, err := decoder.Token()
+			return NewStructRecord(items...), err
+		case '[':
+			var records []Record
+			for decoder.More() {
+				record, err := decodeJSONValue(decoder)
+				if err != nil {
+					return Record{}, err
+				}
+				records = append(records, record)
+			}
+			if _, err := decoder.Token(); err != nil {
+				return Record{}, err
+			}
+			return jsonArrayRecord(records), nil
+		}
+	case json.Number:
+		if !strings.ContainsAny(v.String(), ".eE") {
+			if i, err := v.Int64(); err == nil {
+				return NewIntRecord(int(i)), nil
+			}
+		}
+		f, err := v.Float64()
+		return NewDoubleRecord(f), err
+	case string:
+		return NewStringRecord(v), nil
+	case bool:
+		if v {
+			return NewIntRecord(1), nil
+		}
+		return NewIntRecord(0), nil
+	case nil:
+		return NewStringRecord(""), nil
+	}
+	return Record{}, errors.New("unexpected JSON token")
+}
+
+// jsonArrayRecord converts a JSON array. BINRPC encodes a struct with repeated members
+// where JSON-RPC uses an array of single member objects, e.g. the "SET" members of "dispatcher.list":
+// such arrays are merged into a single struct, so that the collectors see the same records.
+func jsonArrayRecord(records []Record) Record {
+	if len(records) == 0 {
+		return NewArrayRecord()
+	}
+	merged := make([]StructItem, 0, len(records))
+	for _, record := range records {
+		items, err := record.StructItems()
+		if err != nil || len(items) != 1 {
+			return NewArrayRecord(records...)
+		}
+		merged = append(merged, items[0])
+	}
+	return NewStructRecord(merged...)
+}
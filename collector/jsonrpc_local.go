@@ -0,0 +1,163 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// replySequence numbers the reply FIFOs and sockets created by the exporter.
+var replySequence atomic.Uint64
+
+// replyPath returns a new path in dir for a reply FIFO or socket.
+func replyPath(dir, ext string) string {
+	return filepath.Join(dir, fmt.Sprintf("kamailio_exporter_%d_%d%s", os.Getpid(), replySequence.Add(1), ext))
+}
+
+// jsonrpcDatagramTransport speaks JSON-RPC over the unix datagram socket of the jsonrpcs module.
+// Kamailio sends the reply to the address of the request, so the transport binds its own socket.
+type jsonrpcDatagramTransport struct {
+	*datagramConn
+}
+
+func dialJSONRPCDatagram(path, dir string) (*jsonrpcDatagramTransport, error) {
+	local := replyPath(dir, ".sock")
+	conn, err := net.DialUnix("unixgram", &net.UnixAddr{Name: local, Net: "unixgram"}, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	t := &jsonrpcDatagramTransport{&datagramConn{Conn: conn, local: local}}
+	// Kamailio usually runs as another user, which must be allowed to reply
+	if err := os.Chmod(local, 0o666); err != nil {
+		t.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *jsonrpcDatagramTransport) Call(ctx context.Context, method string, args ...string) ([]Record, error) {
+	request, id, err := marshalJSONRPCRequest(method, args)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := t.roundTrip(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalJSONRPCReply(reply, id)
+}
+
+// fifoTransport speaks JSON-RPC over the FIFO of the jsonrpcs module.
+// Each request names the reply FIFO of the transport, which jsonrpcs looks up in its "fifo_reply_dir".
+type fifoTransport struct {
+	path   string
+	reply  *os.File
+	failed bool
+}
+
+func openFIFO(path, dir string) (*fifoTransport, error) {
+	name := replyPath(dir, ".fifo")
+	if err := syscall.Mkfifo(name, 0o666); err != nil {
+		return nil, fmt.Errorf("cannot create reply FIFO: %w", err)
+	}
+	// Kamailio usually runs as another user, which must be allowed to reply
+	if err := os.Chmod(name, 0o666); err != nil {
+		os.Remove(name)
+		return nil, err
+	}
+	// Opening the reply FIFO for writing as well keeps reads waiting for Kamailio instead of
+	// returning EOF while no reply is being written.
+	reply, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		os.Remove(name)
+		return nil, err
+	}
+	return &fifoTransport{path: path, reply: reply}, nil
+}
+
+func (t *fifoTransport) Call(ctx context.Context, method string, args ...string) ([]Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	request, id, err := marshalJSONRPCRequest(method, args)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.send(ctx, request); err != nil {
+		return nil, err
+	}
+
+	deadline, _ := ctx.Deadline()
+	if err := t.reply.SetReadDeadline(deadline); err != nil {
+		t.failed = true
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		// unblock the pending read
+		t.reply.SetReadDeadline(time.Now())
+	})
+	defer stop()
+	var reply json.RawMessage
+	if err := json.NewDecoder(t.reply).Decode(&reply); err != nil {
+		t.failed = true
+		return nil, err
+	}
+	return unmarshalJSONRPCReply(reply, id)
+}
+
+// send writes the request to the FIFO of Kamailio, prefixed with the name of the reply FIFO.
+func (t *fifoTransport) send(ctx context.Context, request []byte) error {
+	// without O_NONBLOCK, opening a FIFO nobody reads would block
+	fifo, err := os.OpenFile(t.path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return err
+	}
+	defer fifo.Close()
+	deadline, _ := ctx.Deadline()
+	if err := fifo.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	command := fmt.Sprintf(":%s:%s\n", filepath.Base(t.reply.Name()), request)
+	_, err = fifo.WriteString(command)
+	return err
+}
+
+func (t *fifoTransport) broken() bool {
+	return t.failed
+}
+
+func (t *fifoTransport) healthy() bool {
+	return !t.failed
+}
+
+func (t *fifoTransport) Close() error {
+	return errors.Join(t.reply.Close(), os.Remove(t.reply.Name()))
+}
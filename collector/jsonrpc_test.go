@@ -0,0 +1,123 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newJSONRPCServer starts an HTTP server answering every request with the status and the body
+// of the reply function, whose "%ID%" is replaced by the ID of the request.
+func newJSONRPCServer(t *testing.T, reply func(req jsonrpcRequest) (int, string)) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req jsonrpcRequest
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.JSONRPC != "2.0" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		status, body := reply(req)
+		w.WriteHeader(status)
+		w.Write([]byte(strings.ReplaceAll(body, "%ID%", strconv.FormatUint(req.ID, 10))))
+	}))
+}
+
+func TestHTTPTransport(t *testing.T) {
+	var calls [][]string
+	server := newJSONRPCServer(t, func(req jsonrpcRequest) (int, string) {
+		calls = append(calls, append([]string{req.Method}, req.Params...))
+		switch req.Method {
+		case "core.test":
+			return http.StatusOK, `{"jsonrpc": "2.0", "result": {
+				"int": 42, "negative": -7, "double": 1.5, "round_double": 2.0, "exponent": 1e3, "string": "text",
+				"nested": {"inner": {"value": 3}},
+				"SET": [{"DEST": {"URI": "sip:a"}}, {"DEST": {"URI": "sip:b"}}],
+				"list": [1, "two"]
+			}, "id": %ID%}`
+		case "core.fail":
+			return http.StatusInternalServerError, `{"jsonrpc": "2.0", "error": {"code": 500, "message": "command failed"}, "id": %ID%}`
+		case "core.unavailable":
+			return http.StatusBadGateway, `<html>Bad Gateway</html>`
+		case "core.unexpected":
+			return http.StatusServiceUnavailable, `{"jsonrpc": "2.0", "result": 1, "id": %ID%}`
+		}
+		return http.StatusNotFound, `{"jsonrpc": "2.0", "error": {"code": -32601, "message": "Method Not Found"}, "id": %ID%}`
+	})
+	defer server.Close()
+
+	transport := &httpTransport{client: newHTTPClient(5 * time.Second), url: server.URL}
+	ctx := context.Background()
+
+	records, err := transport.Call(ctx, "core.test", "arg", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Record{NewStructRecord(
+		StructItem{Key: "int", Value: NewIntRecord(42)},
+		StructItem{Key: "negative", Value: NewIntRecord(-7)},
+		StructItem{Key: "double", Value: NewDoubleRecord(1.5)},
+		StructItem{Key: "round_double", Value: NewDoubleRecord(2)},
+		StructItem{Key: "exponent", Value: NewDoubleRecord(1000)},
+		StructItem{Key: "string", Value: NewStringRecord("text")},
+		StructItem{Key: "nested", Value: NewStructRecord(
+			StructItem{Key: "inner", Value: NewStructRecord(StructItem{Key: "value", Value: NewIntRecord(3)})},
+		)},
+		StructItem{Key: "SET", Value: NewStructRecord(
+			StructItem{Key: "DEST", Value: NewStructRecord(StructItem{Key: "URI", Value: NewStringRecord("sip:a")})},
+			StructItem{Key: "DEST", Value: NewStructRecord(StructItem{Key: "URI", Value: NewStringRecord("sip:b")})},
+		)},
+		StructItem{Key: "list", Value: NewArrayRecord(NewIntRecord(1), NewStringRecord("two"))},
+	)}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("got records %+v, want %+v", records, want)
+	}
+	if want := [][]string{{"core.test", "arg", ""}}; !slices.EqualFunc(calls, want, slices.Equal) {
+		t.Errorf("got calls %q, want %q", calls, want)
+	}
+
+	_, err = transport.Call(ctx, "core.fail")
+	var rpcErr *jsonrpcError
+	if !errors.As(err, &rpcErr) || rpcErr.Code != 500 || rpcErr.Message != "command failed" {
+		t.Errorf("core.fail: got error %v, want the JSON-RPC error object", err)
+	}
+
+	for _, method := range []string{"core.unavailable", "core.unexpected"} {
+		records, err := transport.Call(ctx, method)
+		if err == nil || !strings.Contains(err.Error(), "HTTP status 50") {
+			t.Errorf("%s: got records %v and error %v, want an HTTP status error", method, records, err)
+		}
+	}
+}
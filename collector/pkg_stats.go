@@ -22,7 +22,7 @@
 package collector
 
 import (
-	"net"
+	"context"
 	"strconv"
 
 	"github.com/go-kit/log"
@@ -44,17 +44,23 @@ type PkgStatsEntry struct {
 }
 
 type pkgStatsCollector struct {
-	used   *prometheus.Desc
-	free   *prometheus.Desc
-	real   *prometheus.Desc
-	size   *prometheus.Desc
-	frags  *prometheus.Desc
-	logger log.Logger
-	config *KamailioCollectorConfig
+	used  *prometheus.Desc
+	free  *prometheus.Desc
+	real  *prometheus.Desc
+	size  *prometheus.Desc
+	frags *prometheus.Desc
+	// the metrics of the v2 schema
+	bytes     *prometheus.Desc
+	fragments *prometheus.Desc
+	schemaV1  bool
+	schemaV2  bool
+	logger    log.Logger
+	config    *KamailioCollectorConfig
 }
 
 // NewCoreStatsCollector returns a new Collector exposing core stats.
 func NewPkgStatsCollector(config *KamailioCollectorConfig, logger log.Logger) (Collector, error) {
+	schemaV1, schemaV2 := schemaVersions(config)
 	return &pkgStatsCollector{
 		used: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, "", "pkgmem_used"),
@@ -85,13 +91,41 @@ func NewPkgStatsCollector(config *KamailioCollectorConfig, logger log.Logger) (C
 			"Private memory total frags",
 			[]string{"entry", "pid"},
 			nil),
-		config: config,
-		logger: logger,
+
+		bytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "pkgmem_bytes"),
+			"Private memory sizes",
+			[]string{"entry", "pid", "type"},
+			nil),
+
+		fragments: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "pkgmem_fragments"),
+			"Private memory fragment count",
+			[]string{"entry", "pid"},
+			nil),
+		schemaV1: schemaV1,
+		schemaV2: schemaV2,
+		config:   config,
+		logger:   logger,
 	}, nil
 }
 
-func (c *pkgStatsCollector) Update(conn net.Conn, metricChannel chan<- prometheus.Metric) error {
-	records, err := getRecords(conn, c.logger, "pkg.stats")
+func (c *pkgStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	if c.schemaV1 {
+		ch <- c.used
+		ch <- c.free
+		ch <- c.real
+		ch <- c.size
+		ch <- c.frags
+	}
+	if c.schemaV2 {
+		ch <- c.bytes
+		ch <- c.fragments
+	}
+}
+
+func (c *pkgStatsCollector) Update(ctx context.Context, client Client, metricChannel chan<- prometheus.Metric) error {
+	records, err := getRecords(ctx, client, c.logger, "pkg.stats")
 	if err != nil {
 		return err
 	}
@@ -120,11 +154,21 @@ func (c *pkgStatsCollector) Update(conn net.Conn, metricChannel chan<- prometheu
 		}
 		sentry := strconv.Itoa(entry.entry)
 		spid := strconv.Itoa(entry.pid)
-		metricChannel <- prometheus.MustNewConstMetric(c.used, prometheus.GaugeValue, float64(entry.used), sentry, spid)
-		metricChannel <- prometheus.MustNewConstMetric(c.free, prometheus.GaugeValue, float64(entry.free), sentry, spid)
-		metricChannel <- prometheus.MustNewConstMetric(c.real, prometheus.GaugeValue, float64(entry.realUsed), sentry, spid)
-		metricChannel <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, float64(entry.totalSize), sentry, spid)
-		metricChannel <- prometheus.MustNewConstMetric(c.frags, prometheus.GaugeValue, float64(entry.totalFrags), sentry, spid)
+		if c.schemaV1 {
+			metricChannel <- prometheus.MustNewConstMetric(c.used, prometheus.GaugeValue, float64(entry.used), sentry, spid)
+			metricChannel <- prometheus.MustNewConstMetric(c.free, prometheus.GaugeValue, float64(entry.free), sentry, spid)
+			metricChannel <- prometheus.MustNewConstMetric(c.real, prometheus.GaugeValue, float64(entry.realUsed), sentry, spid)
+			metricChannel <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, float64(entry.totalSize), sentry, spid)
+			metricChannel <- prometheus.MustNewConstMetric(c.frags, prometheus.GaugeValue, float64(entry.totalFrags), sentry, spid)
+		}
+		if c.schemaV2 {
+			// the same types as kamailio_shm_bytes
+			metricChannel <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(entry.used), sentry, spid, "used")
+			metricChannel <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(entry.free), sentry, spid, "free")
+			metricChannel <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(entry.realUsed), sentry, spid, "real_used")
+			metricChannel <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(entry.totalSize), sentry, spid, "total")
+			metricChannel <- prometheus.MustNewConstMetric(c.fragments, prometheus.GaugeValue, float64(entry.totalFrags), sentry, spid)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,228 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+
+	"go.voiplens.io/kamailio/binrpc"
+	"gopkg.in/yaml.v2"
+)
+
+// Record is a value of a Kamailio RPC reply, whatever the transport it was received with.
+// It holds an int, a float64, a string, a struct or an array.
+type Record struct {
+	value any
+}
+
+// StructItem is a member of a struct record. Keys may be repeated within a struct.
+type StructItem struct {
+	Key   string
+	Value Record
+}
+
+// NewIntRecord returns an int record.
+func NewIntRecord(v int) Record {
+	return Record{value: v}
+}
+
+// NewDoubleRecord returns a double record.
+func NewDoubleRecord(v float64) Record {
+	return Record{value: v}
+}
+
+// NewStringRecord returns a string record.
+func NewStringRecord(v string) Record {
+	return Record{value: v}
+}
+
+// NewStructRecord returns a struct record made of the given items.
+func NewStructRecord(items ...StructItem) Record {
+	if items == nil {
+		items = []StructItem{}
+	}
+	return Record{value: items}
+}
+
+// NewArrayRecord returns an array record made of the given records.
+func NewArrayRecord(records ...Record) Record {
+	if records == nil {
+		records = []Record{}
+	}
+	return Record{value: records}
+}
+
+// Int returns the value of an int record.
+func (r Record) Int() (int, error) {
+	if v, ok := r.value.(int); ok {
+		return v, nil
+	}
+	return 0, r.typeError("int")
+}
+
+// Double returns the value of a double record. Int records are converted.
+func (r Record) Double() (float64, error) {
+	switch v := r.value.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	}
+	return 0, r.typeError("double")
+}
+
+// String returns the value of a string record.
+func (r Record) String() (string, error) {
+	if v, ok := r.value.(string); ok {
+		return v, nil
+	}
+	return "", r.typeError("string")
+}
+
+// StructItems returns the members of a struct record.
+func (r Record) StructItems() ([]StructItem, error) {
+	if v, ok := r.value.([]StructItem); ok {
+		return v, nil
+	}
+	return nil, r.typeError("struct")
+}
+
+// Items returns the elements of an array record.
+func (r Record) Items() ([]Record, error) {
+	if v, ok := r.value.([]Record); ok {
+		return v, nil
+	}
+	return nil, r.typeError("array")
+}
+
+// MarshalJSON encodes the record as JSON, keeping the order and the repetitions of the struct members.
+// Doubles always have a fraction or an exponent, to be told apart from ints when decoded.
+func (r Record) MarshalJSON() ([]byte, error) {
+	switch v := r.value.(type) {
+	case int:
+		return strconv.AppendInt(nil, int64(v), 10), nil
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return nil, fmt.Errorf("unsupported double value %v", v)
+		}
+		b := strconv.AppendFloat(nil, v, 'g', -1, 64)
+		if !bytes.ContainsAny(b, ".e") {
+			b = append(b, ".0"...)
+		}
+		return b, nil
+	case string:
+		return json.Marshal(v)
+	case []StructItem:
+		b := []byte{'{'}
+		for i, item := range v {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			key, err := json.Marshal(item.Key)
+			if err != nil {
+				return nil, err
+			}
+			value, err := item.Value.MarshalJSON()
+			if err != nil {
+				return nil, err
+			}
+			b = append(append(append(b, key...), ':'), value...)
+		}
+		return append(b, '}'), nil
+	case []Record:
+		b := []byte{'['}
+		for i, record := range v {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			value, err := record.MarshalJSON()
+			if err != nil {
+				return nil, err
+			}
+			b = append(b, value...)
+		}
+		return append(b, ']'), nil
+	}
+	return []byte("null"), nil
+}
+
+// MarshalYAML encodes the record as YAML, keeping the order and the repetitions of the struct members.
+func (r Record) MarshalYAML() (interface{}, error) {
+	switch v := r.value.(type) {
+	case []StructItem:
+		items := make(yaml.MapSlice, 0, len(v))
+		for _, item := range v {
+			items = append(items, yaml.MapItem{Key: item.Key, Value: item.Value})
+		}
+		return items, nil
+	case []Record:
+		return v, nil
+	}
+	return r.value, nil
+}
+
+// UnmarshalJSON decodes a record encoded by MarshalJSON.
+func (r *Record) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	record, err := decodeJSONValue(decoder)
+	if err != nil {
+		return err
+	}
+	*r = record
+	return nil
+}
+
+func (r Record) typeError(expected string) error {
+	return fmt.Errorf("record is not a %s but a %T", expected, r.value)
+}
+
+// fromBinrpcRecords converts the records of a BINRPC reply.
+func fromBinrpcRecords(records []binrpc.Record) []Record {
+	result := make([]Record, 0, len(records))
+	for _, record := range records {
+		result = append(result, fromBinrpcRecord(record))
+	}
+	return result
+}
+
+func fromBinrpcRecord(record binrpc.Record) Record {
+	if items, err := record.StructItems(); err == nil {
+		result := make([]StructItem, 0, len(items))
+		for _, item := range items {
+			result = append(result, StructItem{Key: item.Key, Value: fromBinrpcRecord(item.Value)})
+		}
+		return NewStructRecord(result...)
+	}
+	if v, err := record.Int(); err == nil {
+		return NewIntRecord(v)
+	}
+	if v, err := record.Double(); err == nil {
+		return NewDoubleRecord(v)
+	}
+	v, _ := record.String()
+	return NewStringRecord(v)
+}
@@ -0,0 +1,174 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package collector
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// recording is an RPC call and its reply, stored as a JSON file by the recordingTransport.
+type recording struct {
+	Method  string   `json:"method"`
+	Args    []string `json:"args,omitempty"`
+	Records []Record `json:"records,omitempty"`
+	// Error is the error replied by Kamailio, if any.
+	Error string `json:"error,omitempty"`
+}
+
+func (r recording) key() string {
+	return callKey(r.Method, r.Args)
+}
+
+func callKey(method string, args []string) string {
+	return strings.Join(append([]string{method}, args...), "\x00")
+}
+
+// fileName returns the name of the recording file: the method and its arguments, with the characters
+// which are not safe in a file name replaced, and a hash of the call telling apart the calls this makes alike.
+func (r recording) fileName() string {
+	name := strings.Join(append([]string{r.Method}, r.Args...), "_")
+	name = strings.Map(func(c rune) rune {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_' {
+			return c
+		}
+		return '_'
+	}, name)
+	sum := sha256.Sum256([]byte(r.key()))
+	return fmt.Sprintf("%s-%x.json", name, sum[:4])
+}
+
+// recordingTransport stores the replies of the wrapped transport in a directory, one file per method and arguments.
+// A file holds the last reply, so that the directory can be replayed by a replayTransport.
+type recordingTransport struct {
+	transport
+	dir    string
+	logger log.Logger
+}
+
+func (t *recordingTransport) Call(ctx context.Context, method string, args ...string) ([]Record, error) {
+	records, err := t.transport.Call(ctx, method, args...)
+	// a canceled call or a lost connection tells nothing about Kamailio
+	if err != nil && (ctx.Err() != nil || t.transport.broken()) {
+		return records, err
+	}
+
+	r := recording{Method: method, Args: args, Records: records}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	if err := r.write(t.dir); err != nil {
+		level.Error(t.logger).Log("msg", "Can not record the RPC reply", "cmd", method, "err", err)
+	}
+	return records, err
+}
+
+// write stores the recording in dir, replacing the previous one of the same call.
+func (r recording) write(dir string) error {
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	// concurrent scrapes may record the same call: the file is replaced at once
+	f, err := os.CreateTemp(dir, ".recording-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(content, '\n'))
+	if err = errors.Join(err, f.Close()); err == nil {
+		err = os.Rename(f.Name(), filepath.Join(dir, r.fileName()))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// replayTransport answers the calls with the replies stored by a recordingTransport, without any Kamailio.
+type replayTransport struct {
+	dir        string
+	recordings map[string]recording
+}
+
+// NewReplayClient returns a Client answering the calls with the replies recorded in dir with --debug.record-dir,
+// e.g. to test the collectors against the replies of an actual Kamailio.
+func NewReplayClient(dir string) (Client, error) {
+	return loadRecordings(dir)
+}
+
+func loadRecordings(dir string) (*replayTransport, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no recording found in %s", dir)
+	}
+	t := &replayTransport{dir: dir, recordings: make(map[string]recording)}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var r recording
+		if err := json.Unmarshal(content, &r); err != nil {
+			return nil, fmt.Errorf("invalid recording %s: %w", file, err)
+		}
+		t.recordings[r.key()] = r
+	}
+	return t, nil
+}
+
+func (t *replayTransport) Call(ctx context.Context, method string, args ...string) ([]Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r, ok := t.recordings[callKey(method, args)]
+	if !ok {
+		return nil, fmt.Errorf("no recording of %q in %s", strings.Join(append([]string{method}, args...), " "), t.dir)
+	}
+	if r.Error != "" {
+		return nil, errors.New(r.Error)
+	}
+	return r.Records, nil
+}
+
+func (t *replayTransport) broken() bool {
+	return false
+}
+
+func (t *replayTransport) healthy() bool {
+	return true
+}
+
+func (t *replayTransport) Close() error {
+	return nil
+}
@@ -0,0 +1,74 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package collector
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+)
+
+// echoTransport replies to a call with its arguments.
+type echoTransport struct {
+	idleTransport
+}
+
+func (echoTransport) Call(_ context.Context, method string, args ...string) ([]Record, error) {
+	return []Record{NewStringRecord(strings.Join(args, "|"))}, nil
+}
+
+func TestRecordingSeparators(t *testing.T) {
+	dir := t.TempDir()
+	recorder := &recordingTransport{transport: echoTransport{}, dir: dir, logger: log.NewNopLogger()}
+	calls := [][]string{{"a_b"}, {"a b"}, {"a", "b"}, {"x/y"}, {"x_y"}}
+	ctx := context.Background()
+	for _, args := range calls {
+		if _, err := recorder.Call(ctx, "stats.fetch", args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(calls) {
+		t.Errorf("got %d recording files, want %d", len(files), len(calls))
+	}
+
+	replay, err := NewReplayClient(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range calls {
+		records, err := replay.Call(ctx, "stats.fetch", args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := records[0].String(); got != strings.Join(args, "|") {
+			t.Errorf("replay of %q = %q, want %q", args, got, strings.Join(args, "|"))
+		}
+	}
+}
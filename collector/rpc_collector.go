@@ -0,0 +1,295 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package collector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// RPCCollectorConfig declares a collector turning the reply of any RPC command into metrics.
+//
+// The records of the reply are walked down the Path: each step selects the members of the structs
+// with that name, or every element of the arrays and structs with "*". Every value reached is a row:
+// its fields given in Labels become the label values, and the fields of Metrics the metric values.
+type RPCCollectorConfig struct {
+	// Name of the collector, defaults to the method.
+	Name   string   `yaml:"name,omitempty"`
+	Method string   `yaml:"method"`
+	Params []string `yaml:"params,omitempty"`
+	Path   []string `yaml:"path,omitempty"`
+	// Labels maps the label names to the fields holding their values.
+	Labels  map[string]string `yaml:"labels,omitempty"`
+	Metrics []RPCMetricConfig `yaml:"metrics"`
+}
+
+// RPCMetricConfig declares a metric of an RPCCollectorConfig.
+type RPCMetricConfig struct {
+	// Name of the metric, prefixed with "kamailio_".
+	Name string `yaml:"name"`
+	Help string `yaml:"help,omitempty"`
+	// Type is "gauge" or "counter", defaults to "gauge".
+	Type string `yaml:"type,omitempty"`
+	// Field holding the value: a member of the row, or a path to a nested member such as "LATENCY.AVG".
+	Field string `yaml:"field"`
+}
+
+// CollectorName returns the name of the collector.
+func (c RPCCollectorConfig) CollectorName() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Method
+}
+
+// Validate returns an error if the collector cannot produce valid metrics.
+func (c RPCCollectorConfig) Validate() error {
+	if c.Method == "" {
+		return fmt.Errorf("rpc collector %q: method is missing", c.Name)
+	}
+	name := c.CollectorName()
+	if _, ok := factories[name]; ok {
+		return fmt.Errorf("rpc collector %q: name is already used by a built-in collector", name)
+	}
+	if len(c.Metrics) == 0 {
+		return fmt.Errorf("rpc collector %q: no metrics", name)
+	}
+	for label, field := range c.Labels {
+		if !model.LabelName(label).IsValid() || strings.HasPrefix(label, "__") {
+			return fmt.Errorf("rpc collector %q: invalid label name %q", name, label)
+		}
+		if field == "" {
+			return fmt.Errorf("rpc collector %q: field of label %q is missing", name, label)
+		}
+	}
+	metrics := make(map[string]bool)
+	for _, m := range c.Metrics {
+		fqName := prometheus.BuildFQName(namespace, "", m.Name)
+		if m.Name == "" || !model.IsValidMetricName(model.LabelValue(fqName)) {
+			return fmt.Errorf("rpc collector %q: invalid metric name %q", name, m.Name)
+		}
+		if metrics[fqName] {
+			return fmt.Errorf("rpc collector %q: metric %q is declared twice", name, m.Name)
+		}
+		metrics[fqName] = true
+		if m.Field == "" {
+			return fmt.Errorf("rpc collector %q: field of metric %q is missing", name, m.Name)
+		}
+		if _, err := parseValueType(m.Type); err != nil {
+			return fmt.Errorf("rpc collector %q: metric %q: %w", name, m.Name, err)
+		}
+	}
+	return nil
+}
+
+func parseValueType(t string) (prometheus.ValueType, error) {
+	switch t {
+	case "", "gauge":
+		return prometheus.GaugeValue, nil
+	case "counter":
+		return prometheus.CounterValue, nil
+	}
+	return 0, fmt.Errorf("unknown metric type %q, expected gauge or counter", t)
+}
+
+// rpcMetric is a metric of a rpcCollector.
+type rpcMetric struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	field     string
+}
+
+type rpcCollector struct {
+	config RPCCollectorConfig
+	// labels holds the label names, sorted.
+	labels  []string
+	metrics []rpcMetric
+	logger  log.Logger
+}
+
+// newRPCCollector returns a Collector exposing the metrics declared by the configuration.
+func newRPCCollector(config RPCCollectorConfig, logger log.Logger) (*rpcCollector, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	c := &rpcCollector{config: config, logger: logger}
+	for label := range config.Labels {
+		c.labels = append(c.labels, label)
+	}
+	slices.Sort(c.labels)
+	for _, m := range config.Metrics {
+		help := m.Help
+		if help == "" {
+			help = fmt.Sprintf("Field %s of %s.", m.Field, config.Method)
+		}
+		valueType, _ := parseValueType(m.Type)
+		c.metrics = append(c.metrics, rpcMetric{
+			desc:      prometheus.NewDesc(prometheus.BuildFQName(namespace, "", m.Name), help, c.labels, nil),
+			valueType: valueType,
+			field:     m.Field,
+		})
+	}
+	return c, nil
+}
+
+// method returns the RPC method of the collector, which Kamailio must support to run it.
+func (c *rpcCollector) method() string {
+	return c.config.Method
+}
+
+func (c *rpcCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics {
+		ch <- m.desc
+	}
+}
+
+func (c *rpcCollector) Update(ctx context.Context, client Client, metricChannel chan<- prometheus.Metric) error {
+	records, err := getRecords(ctx, client, c.logger, append([]string{c.config.Method}, c.config.Params...)...)
+	if err != nil {
+		return err
+	}
+
+	rows := records
+	for _, step := range c.config.Path {
+		rows = selectRecords(rows, step)
+	}
+
+	// rows with the same label values would make the whole scrape fail: only the first one is kept
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		values := make([]string, 0, len(c.labels))
+		for _, label := range c.labels {
+			value, _ := fieldRecord(row, c.config.Labels[label])
+			values = append(values, recordText(value))
+		}
+		key := strings.Join(values, "\x00")
+		if seen[key] {
+			level.Warn(c.logger).Log("msg", "Skipping a row with duplicate label values", "method", c.config.Method, "labels", strings.Join(values, ","))
+			continue
+		}
+		seen[key] = true
+
+		for _, m := range c.metrics {
+			record, ok := fieldRecord(row, m.field)
+			if !ok {
+				continue
+			}
+			value, err := recordValue(record)
+			if err != nil {
+				level.Debug(c.logger).Log("msg", "Skipping a field without numeric value", "method", c.config.Method, "field", m.field, "err", err)
+				continue
+			}
+			metricChannel <- prometheus.MustNewConstMetric(m.desc, m.valueType, value, values...)
+		}
+	}
+	return nil
+}
+
+// selectRecords returns the members named step of the struct records, or with "*"
+// the elements of the array records and the members of the struct records.
+func selectRecords(records []Record, step string) []Record {
+	var selected []Record
+	for _, record := range records {
+		if step == "*" {
+			if items, err := record.Items(); err == nil {
+				selected = append(selected, items...)
+				continue
+			}
+		}
+		items, err := record.StructItems()
+		if err != nil {
+			continue
+		}
+		for _, item := range items {
+			if step == "*" || item.Key == step {
+				selected = append(selected, item.Value)
+			}
+		}
+	}
+	return selected
+}
+
+// fieldRecord returns the first member of the struct record named field. Kamailio member names may hold dots:
+// a field not found as such is looked up as a path of nested members, e.g. "LATENCY.AVG".
+func fieldRecord(record Record, field string) (Record, bool) {
+	items, err := record.StructItems()
+	if err != nil {
+		return Record{}, false
+	}
+	for _, item := range items {
+		if item.Key == field {
+			return item.Value, true
+		}
+	}
+	for i := strings.IndexByte(field, '.'); i >= 0; i = nextDot(field, i) {
+		for _, item := range items {
+			if item.Key == field[:i] {
+				if value, ok := fieldRecord(item.Value, field[i+1:]); ok {
+					return value, true
+				}
+			}
+		}
+	}
+	return Record{}, false
+}
+
+func nextDot(s string, i int) int {
+	j := strings.IndexByte(s[i+1:], '.')
+	if j < 0 {
+		return -1
+	}
+	return i + 1 + j
+}
+
+// recordValue returns the numeric value of a record. Strings are parsed, as most statistics are sent as strings.
+func recordValue(record Record) (float64, error) {
+	if v, err := record.Double(); err == nil {
+		return v, nil
+	}
+	s, err := record.String()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimSpace(s), 64)
+}
+
+// recordText returns the text of a scalar record, used as a label value.
+func recordText(record Record) string {
+	if s, err := record.String(); err == nil {
+		return s
+	}
+	if i, err := record.Int(); err == nil {
+		return strconv.Itoa(i)
+	}
+	if f, err := record.Double(); err == nil {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return ""
+}
@@ -0,0 +1,165 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package collector
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/voiplens/kamailio_exporter/collector/binrpctest"
+)
+
+// dest returns a destination of a dispatcher.list reply.
+func dest(uri, flags string, members ...binrpctest.Member) binrpctest.Member {
+	return binrpctest.Member{Name: "DEST", Value: append(binrpctest.Struct{
+		{Name: "URI", Value: uri},
+		{Name: "FLAGS", Value: flags},
+	}, members...)}
+}
+
+func TestRPCCollectorDispatcherDestinations(t *testing.T) {
+	server := binrpctest.NewServer()
+	defer server.Close()
+	set := func(id int, destinations ...binrpctest.Member) binrpctest.Member {
+		return binrpctest.Member{Name: "SET", Value: binrpctest.Struct{
+			{Name: "ID", Value: id},
+			{Name: "TARGETS", Value: binrpctest.Struct(destinations)},
+		}}
+	}
+	server.Reply("dispatcher.list", binrpctest.Struct{
+		{Name: "NRSETS", Value: 2},
+		{Name: "RECORDS", Value: binrpctest.Struct{
+			set(1,
+				dest("sip:10.0.0.1", "AP",
+					binrpctest.Member{Name: "PRIORITY", Value: "5"},
+					binrpctest.Member{Name: "ATTRS", Value: binrpctest.Struct{{Name: "BODY", Value: "weight=50"}}},
+					binrpctest.Member{Name: "LATENCY", Value: binrpctest.Struct{{Name: "AVG", Value: 12.5}}},
+				),
+				dest("sip:10.0.0.2", "IP",
+					binrpctest.Member{Name: "PRIORITY", Value: 0},
+					binrpctest.Member{Name: "LATENCY", Value: binrpctest.Struct{{Name: "AVG", Value: 3}}},
+				),
+				dest("sip:10.0.0.3", "DX"),
+			),
+			// the same destination in another set has the same labels
+			set(2, dest("sip:10.0.0.1", "AP",
+				binrpctest.Member{Name: "LATENCY", Value: binrpctest.Struct{{Name: "AVG", Value: 99.0}}},
+			)),
+		}},
+	})
+
+	config := testConfig(server.URI)
+	// the example of the README, with a numeric string and a text field
+	config.RPCCollectors = []RPCCollectorConfig{{
+		Name:   "dispatcher_destinations",
+		Method: "dispatcher.list",
+		Params: []string{},
+		Path:   []string{"RECORDS", "SET", "TARGETS", "DEST"},
+		Labels: map[string]string{"destination": "URI", "flags": "FLAGS"},
+		Metrics: []RPCMetricConfig{
+			{Name: "dispatcher_destination_latency_avg", Help: "Average latency of the destination, in milliseconds.", Type: "gauge", Field: "LATENCY.AVG"},
+			{Name: "dispatcher_destination_priority", Field: "PRIORITY"},
+			{Name: "dispatcher_destination_body", Field: "ATTRS.BODY"},
+		},
+	}}
+	c, err := NewKamailioCollector(config, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	metrics := gatherMetrics(t, c)
+	want := map[string]float64{
+		`kamailio_dispatcher_destination_latency_avg{destination="sip:10.0.0.1",flags="AP"}`: 12.5,
+		`kamailio_dispatcher_destination_latency_avg{destination="sip:10.0.0.2",flags="IP"}`: 3,
+		`kamailio_dispatcher_destination_priority{destination="sip:10.0.0.1",flags="AP"}`:    5,
+		`kamailio_dispatcher_destination_priority{destination="sip:10.0.0.2",flags="IP"}`:    0,
+		`kamailio_scrape_collector_success{collector="dispatcher_destinations"}`:             1,
+	}
+	for key, value := range want {
+		if got, ok := metrics[key]; !ok || got != value {
+			t.Errorf("%s = %v (found: %v), want %v", key, got, ok, value)
+		}
+	}
+	for key := range metrics {
+		if _, ok := want[key]; !ok && strings.HasPrefix(key, "kamailio_dispatcher_destination") {
+			t.Errorf("unexpected series %s", key)
+		}
+	}
+}
+
+func TestSelectRecords(t *testing.T) {
+	records := []Record{NewStructRecord(
+		StructItem{Key: "a", Value: NewArrayRecord(NewIntRecord(1), NewIntRecord(2))},
+		StructItem{Key: "b", Value: NewStructRecord(StructItem{Key: "x", Value: NewIntRecord(3)})},
+		StructItem{Key: "a", Value: NewIntRecord(4)},
+	)}
+	for _, tc := range []struct {
+		path []string
+		want []Record
+	}{
+		{[]string{"a"}, []Record{NewArrayRecord(NewIntRecord(1), NewIntRecord(2)), NewIntRecord(4)}},
+		{[]string{"a", "*"}, []Record{NewIntRecord(1), NewIntRecord(2)}},
+		{[]string{"*", "x"}, []Record{NewIntRecord(3)}},
+		{[]string{"*", "*"}, []Record{NewIntRecord(1), NewIntRecord(2), NewIntRecord(3)}},
+		{[]string{"c"}, nil},
+	} {
+		got := records
+		for _, step := range tc.path {
+			got = selectRecords(got, step)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("path %v selects %+v, want %+v", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestFieldRecord(t *testing.T) {
+	record := NewStructRecord(
+		StructItem{Key: "dialog.active", Value: NewIntRecord(1)},
+		StructItem{Key: "LATENCY", Value: NewStructRecord(
+			StructItem{Key: "AVG", Value: NewDoubleRecord(2.5)},
+			StructItem{Key: "a.b", Value: NewStructRecord(StructItem{Key: "c", Value: NewIntRecord(3)})},
+		)},
+		StructItem{Key: "PRIORITY", Value: NewStringRecord("4")},
+	)
+	for _, tc := range []struct {
+		field string
+		want  Record
+		found bool
+	}{
+		{"dialog.active", NewIntRecord(1), true},
+		{"LATENCY.AVG", NewDoubleRecord(2.5), true},
+		{"LATENCY.a.b.c", NewIntRecord(3), true},
+		{"PRIORITY", NewStringRecord("4"), true},
+		{"LATENCY.MAX", Record{}, false},
+		{"dialog", Record{}, false},
+		{"PRIORITY.x", Record{}, false},
+	} {
+		got, found := fieldRecord(record, tc.field)
+		if found != tc.found || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("fieldRecord(%q) = %+v, %v, want %+v, %v", tc.field, got, found, tc.want, tc.found)
+		}
+	}
+}
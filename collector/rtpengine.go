@@ -22,7 +22,7 @@
 package collector
 
 import (
-	"net"
+	"context"
 	"strconv"
 
 	"github.com/go-kit/log"
@@ -53,9 +53,13 @@ func NewRtpengineCollector(config *KamailioCollectorConfig, logger log.Logger) (
 	}, nil
 }
 
-func (c *rtpengineStatsCollector) Update(conn net.Conn, metricChannel chan<- prometheus.Metric) error {
+func (c *rtpengineStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.rtpengineEnabled
+}
+
+func (c *rtpengineStatsCollector) Update(ctx context.Context, client Client, metricChannel chan<- prometheus.Metric) error {
 	// fetch rtpengine disabled status and url
-	records, err := getRecords(conn, c.logger, "rtpengine.show", "all")
+	records, err := getRecords(ctx, client, c.logger, "rtpengine.show", "all")
 	if err != nil {
 		return err
 	}
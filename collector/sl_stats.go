@@ -22,7 +22,7 @@
 package collector
 
 import (
-	"net"
+	"context"
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
@@ -47,8 +47,12 @@ func NewSlStatsCollector(config *KamailioCollectorConfig, logger log.Logger) (Co
 	}, nil
 }
 
-func (c *slStatsCollector) Update(conn net.Conn, metricChannel chan<- prometheus.Metric) error {
-	records, err := getRecords(conn, c.logger, "sl.stats")
+func (c *slStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.codes
+}
+
+func (c *slStatsCollector) Update(ctx context.Context, client Client, metricChannel chan<- prometheus.Metric) error {
+	records, err := getRecords(ctx, client, c.logger, "sl.stats")
 	if err != nil {
 		return err
 	}
@@ -22,12 +22,18 @@
 package collector
 
 import (
-	"net"
+	"context"
+	"fmt"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
 )
 
 func init() {
@@ -54,12 +60,129 @@ type StatsFetchCollector struct {
 	tmx                 *prometheus.Desc
 	tmxRplTotal         *prometheus.Desc
 	dialog              *prometheus.Desc
+	badMsgHdrTotal      *prometheus.Desc
+	tcpWritequeueBytes  *prometheus.Desc
+	dialogs             *prometheus.Desc
+	dialogsTotal        *prometheus.Desc
+	stat                *prometheus.Desc
+	statTotal           *prometheus.Desc
+	scriptedInvalid     *prometheus.Desc
+	groupSuccess        *prometheus.Desc
+	groupDuration       *prometheus.Desc
+	statTypes           []StatTypeRule
+	scriptedMetrics     []ScriptedMetricRule
+	reservedNames       map[string]bool
+	schemaV1            bool
+	schemaV2            bool
 	logger              log.Logger
 	config              *KamailioCollectorConfig
 }
 
+// StatTypeRule gives the type of the statistics whose "group.name" matches the glob pattern,
+// when they are exported by the generic kamailio_stat_gauge and kamailio_stat_counter_total metrics.
+type StatTypeRule struct {
+	Match string `yaml:"match"`
+	// Type is "gauge" or "counter".
+	Type string `yaml:"type"`
+}
+
+// Validate returns an error if the pattern or the type is invalid.
+func (r StatTypeRule) Validate() error {
+	if _, err := path.Match(r.Match, ""); err != nil {
+		return fmt.Errorf("invalid stat pattern %q: %w", r.Match, err)
+	}
+	if r.Type != "gauge" && r.Type != "counter" {
+		return fmt.Errorf("invalid type %q of stat pattern %q, expected gauge or counter", r.Type, r.Match)
+	}
+	return nil
+}
+
+// defaultStatTypes are the types of the usual statistics, applied after the configured rules.
+// Statistics matching no rule are gauges.
+var defaultStatTypes = []StatTypeRule{
+	{Match: "*.current_*", Type: "gauge"},
+	{Match: "*_current_*", Type: "gauge"},
+	{Match: "shmem.*", Type: "gauge"},
+	{Match: "usrloc.*", Type: "gauge"},
+	{Match: "core.*", Type: "counter"},
+	{Match: "dns.*", Type: "counter"},
+	{Match: "sl.*", Type: "counter"},
+	{Match: "tcp.*", Type: "counter"},
+	{Match: "*_requests", Type: "counter"},
+	{Match: "*_replies", Type: "counter"},
+	{Match: "*_transactions", Type: "counter"},
+	{Match: "registrar.accepted_regs", Type: "counter"},
+	{Match: "registrar.rejected_regs", Type: "counter"},
+	{Match: "websocket.ws_*_connections", Type: "counter"},
+	{Match: "websocket.ws_*_frames", Type: "counter"},
+	{Match: "websocket.ws_*_handshakes", Type: "counter"},
+}
+
+// ParseStatTypes parses the types of the statistics given in the "PATTERN:TYPE" format.
+func ParseStatTypes(statTypes *[]string, logger log.Logger) []StatTypeRule {
+	var rules []StatTypeRule
+	for _, entry := range *statTypes {
+		i := strings.LastIndex(entry, ":")
+		if i < 0 {
+			level.Warn(logger).Log("msg", "Invalid stat type. Removing the entry", "type", entry)
+			continue
+		}
+		rule := StatTypeRule{Match: entry[:i], Type: entry[i+1:]}
+		if err := rule.Validate(); err != nil {
+			level.Warn(logger).Log("msg", "Invalid stat type. Removing the entry", "type", entry, "err", err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// statValueType returns the type of the statistic given by the first matching rule.
+func statValueType(rules []StatTypeRule, key string) prometheus.ValueType {
+	for _, rule := range rules {
+		if ok, _ := path.Match(rule.Match, key); ok {
+			if rule.Type == "counter" {
+				return prometheus.CounterValue
+			}
+			return prometheus.GaugeValue
+		}
+	}
+	return prometheus.GaugeValue
+}
+
+// ScriptedMetricRule describes the scripted metrics whose name, without the "script." prefix
+// and the label pairs, matches the glob pattern.
+type ScriptedMetricRule struct {
+	Match string `yaml:"match"`
+	// Type is "gauge" or "counter". It is deduced from the suffix of the name when empty.
+	Type string `yaml:"type,omitempty"`
+	Help string `yaml:"help,omitempty"`
+	// Unit is appended to the name, before the "_total" suffix, unless the name already ends with it.
+	Unit string `yaml:"unit,omitempty"`
+	// Rename replaces the name of the metric, which is still prefixed by "kamailio_".
+	Rename string `yaml:"rename,omitempty"`
+}
+
+// Validate returns an error if the pattern, the type, the unit or the new name is invalid.
+func (r ScriptedMetricRule) Validate() error {
+	if _, err := path.Match(r.Match, ""); err != nil {
+		return fmt.Errorf("invalid scripted metric pattern %q: %w", r.Match, err)
+	}
+	if _, err := parseValueType(r.Type); err != nil {
+		return fmt.Errorf("scripted metric pattern %q: %w", r.Match, err)
+	}
+	if r.Unit != "" && !model.IsValidMetricName(model.LabelValue("kamailio_"+r.Unit)) {
+		return fmt.Errorf("invalid unit %q of scripted metric pattern %q", r.Unit, r.Match)
+	}
+	if r.Rename != "" && !model.IsValidMetricName(model.LabelValue("kamailio_"+r.Rename)) {
+		return fmt.Errorf("invalid new name %q of scripted metric pattern %q", r.Rename, r.Match)
+	}
+	return nil
+}
+
 // NewStatsFetchCollector returns a new Collector exposing core stats.
 func NewStatsFetchCollector(config *KamailioCollectorConfig, logger log.Logger) (Collector, error) {
+	schemaV1, schemaV2 := schemaVersions(config)
 	return &StatsFetchCollector{
 		coreRequestTotal: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, "", "core_request_total"),
@@ -155,28 +278,124 @@ func NewStatsFetchCollector(config *KamailioCollectorConfig, logger log.Logger)
 			prometheus.BuildFQName(namespace, "", "dialog"),
 			"Ongoing Dialogs",
 			[]string{"type"}, nil),
-		logger: logger,
-		config: config,
+
+		// the metrics of the v2 schema
+		badMsgHdrTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "bad_msg_hdr_total"),
+			"Messages with bad message header",
+			[]string{}, nil),
+
+		tcpWritequeueBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "tcp_writequeue_bytes"),
+			"TCP write queue size",
+			[]string{}, nil),
+
+		dialogs: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "dialogs_active"),
+			"Ongoing dialogs",
+			[]string{"type"}, nil),
+
+		dialogsTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "dialogs_ended_total"),
+			"Ended dialogs",
+			[]string{"type"}, nil),
+
+		stat: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "stat_gauge"),
+			"Statistic of stats.fetch without a dedicated metric",
+			[]string{"group", "name"}, nil),
+
+		statTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "stat_counter_total"),
+			"Counter statistic of stats.fetch without a dedicated metric",
+			[]string{"group", "name"}, nil),
+
+		scriptedInvalid: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "scripted_metrics_invalid"),
+			"Scripted statistics skipped because of an invalid or conflicting name",
+			[]string{}, nil),
+
+		groupSuccess: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "stats_fetch", "group_success"),
+			"kamailio_exporter: Whether the statistics of a group were fetched.",
+			[]string{"group"}, nil),
+
+		groupDuration: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "stats_fetch", "group_duration_seconds"),
+			"kamailio_exporter: Duration of the fetch of the statistics of a group.",
+			[]string{"group"}, nil),
+		statTypes:       append(append([]StatTypeRule{}, config.StatTypes...), defaultStatTypes...),
+		scriptedMetrics: config.ScriptedMetrics,
+		schemaV1:        schemaV1,
+		schemaV2:        schemaV2,
+		logger:          logger,
+		config:          config,
 	}, nil
 }
 
-func (c *StatsFetchCollector) Update(conn net.Conn, metricChannel chan<- prometheus.Metric) error {
-	records, err := getRecords(conn, c.logger, "stats.fetch", "all")
-	if err != nil {
-		return err
+// Describe sends the descs of the well-known stats. The scripted metrics are only known
+// at scrape time, so they are not described and remain unchecked by the registry.
+func (c *StatsFetchCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.coreRequestTotal
+	ch <- c.coreRcvRequestTotal
+	ch <- c.coreReplyTotal
+	ch <- c.coreRcvReplyTotal
+	ch <- c.shmemBytes
+	ch <- c.shmemFragments
+	ch <- c.dnsFailed
+	ch <- c.badURI
+	ch <- c.slReplyTotal
+	ch <- c.slTypeTotal
+	ch <- c.tcpTotal
+	ch <- c.tcpConnections
+	ch <- c.tmxCodeTotal
+	ch <- c.tmxTypeTotal
+	ch <- c.tmx
+	ch <- c.tmxRplTotal
+	if c.schemaV1 {
+		ch <- c.badMsgHdr
+		ch <- c.tcpWritequeue
+		ch <- c.dialog
 	}
+	if c.schemaV2 {
+		ch <- c.badMsgHdrTotal
+		ch <- c.tcpWritequeueBytes
+		ch <- c.dialogs
+		ch <- c.dialogsTotal
+	}
+	ch <- c.stat
+	ch <- c.statTotal
+	ch <- c.scriptedInvalid
+	ch <- c.groupSuccess
+	ch <- c.groupDuration
+}
 
-	// convert the structure into a simple key=>value map
-	items, _ := records[0].StructItems()
+func (c *StatsFetchCollector) Update(ctx context.Context, client Client, metricChannel chan<- prometheus.Metric) error {
+	groups := []string{"all"}
+	if c.config.StatGroups != nil && len(*c.config.StatGroups) > 0 {
+		groups = *c.config.StatGroups
+	}
+
+	// fetch each group into a simple key=>value map, the collector only fails if every group failed
 	completeStatMap := make(map[string]string)
-	for _, item := range items {
-		value, _ := item.Value.String()
-		completeStatMap[item.Key] = value
+	var err error
+	failed := 0
+	for _, group := range groups {
+		if fetchErr := c.fetchGroup(ctx, client, group, completeStatMap, metricChannel); fetchErr != nil {
+			err = fetchErr
+			failed++
+		}
+	}
+	if failed == len(groups) {
+		return err
 	}
+
 	// and produce various prometheus.Metric for well-known stats
 	produceMetrics(completeStatMap, c, metricChannel)
 	// produce prometheus.Metric objects for scripted stats (if any)
-	convertScriptedMetrics(completeStatMap, metricChannel)
+	convertScriptedMetrics(completeStatMap, c, metricChannel)
+	// and the generic metrics for the stats left
+	convertGenericMetrics(completeStatMap, c, metricChannel)
 
 	return nil
 }
@@ -237,7 +456,9 @@ func produceMetrics(completeStatMap map[string]string, c *StatsFetchCollector, m
 	convertStatToMetric(completeStatMap, "shmem.fragments", "", c.shmemFragments, metricChannel, prometheus.GaugeValue)
 	convertStatToMetric(completeStatMap, "dns.failed_dns_request", "", c.dnsFailed, metricChannel, prometheus.CounterValue)
 	convertStatToMetric(completeStatMap, "core.bad_URIs_rcvd", "", c.badURI, metricChannel, prometheus.CounterValue)
-	convertStatToMetric(completeStatMap, "core.bad_msg_hdr", "", c.badMsgHdr, metricChannel, prometheus.CounterValue)
+	c.convertStatToSchemaMetrics(completeStatMap, "core.bad_msg_hdr",
+		schemaMetric{c.badMsgHdr, "", prometheus.CounterValue},
+		schemaMetric{c.badMsgHdrTotal, "", prometheus.CounterValue}, metricChannel)
 
 	// kamailio_sl_reply_total
 	convertStatToMetric(completeStatMap, "sl.1xx_replies", "1xx", c.slReplyTotal, metricChannel, prometheus.CounterValue)
@@ -279,8 +500,10 @@ func produceMetrics(completeStatMap map[string]string, c *StatsFetchCollector, m
 	convertStatToMetric(completeStatMap, "tcp.sendq_full", "sendq_full", c.tcpTotal, metricChannel, prometheus.CounterValue)
 	// kamailio_tcp_connections
 	convertStatToMetric(completeStatMap, "tcp.current_opened_connections", "", c.tcpConnections, metricChannel, prometheus.GaugeValue)
-	// kamailio_tcp_writequeue
-	convertStatToMetric(completeStatMap, "tcp.current_write_queue_size", "", c.tcpWritequeue, metricChannel, prometheus.GaugeValue)
+	// kamailio_tcp_writequeue or kamailio_tcp_writequeue_bytes
+	c.convertStatToSchemaMetrics(completeStatMap, "tcp.current_write_queue_size",
+		schemaMetric{c.tcpWritequeue, "", prometheus.GaugeValue},
+		schemaMetric{c.tcpWritequeueBytes, "", prometheus.GaugeValue}, metricChannel)
 
 	// kamailio_tmx_code_total
 	convertStatToMetric(completeStatMap, "tmx.2xx_transactions", "2xx", c.tmxCodeTotal, metricChannel, prometheus.CounterValue)
@@ -302,41 +525,275 @@ func produceMetrics(completeStatMap map[string]string, c *StatsFetchCollector, m
 	convertStatToMetric(completeStatMap, "tmx.rpl_relayed", "relayed", c.tmxRplTotal, metricChannel, prometheus.CounterValue)
 	convertStatToMetric(completeStatMap, "tmx.rpl_sent", "sent", c.tmxRplTotal, metricChannel, prometheus.CounterValue)
 
-	// kamailio_dialog
-	convertStatToMetric(completeStatMap, "dialog.active_dialogs", "active_dialogs", c.dialog, metricChannel, prometheus.CounterValue)
-	convertStatToMetric(completeStatMap, "dialog.early_dialogs", "early_dialogs", c.dialog, metricChannel, prometheus.CounterValue)
-	convertStatToMetric(completeStatMap, "dialog.expired_dialogs", "expired_dialogs", c.dialog, metricChannel, prometheus.CounterValue)
-	convertStatToMetric(completeStatMap, "dialog.failed_dialogs", "failed_dialogs", c.dialog, metricChannel, prometheus.CounterValue)
-	convertStatToMetric(completeStatMap, "dialog.processed_dialogs", "processed_dialogs", c.dialog, metricChannel, prometheus.CounterValue)
+	// kamailio_dialog, or kamailio_dialogs_active and kamailio_dialogs_ended_total
+	c.convertStatToSchemaMetrics(completeStatMap, "dialog.active_dialogs",
+		schemaMetric{c.dialog, "active_dialogs", prometheus.CounterValue},
+		schemaMetric{c.dialogs, "active", prometheus.GaugeValue}, metricChannel)
+	c.convertStatToSchemaMetrics(completeStatMap, "dialog.early_dialogs",
+		schemaMetric{c.dialog, "early_dialogs", prometheus.CounterValue},
+		schemaMetric{c.dialogs, "early", prometheus.GaugeValue}, metricChannel)
+	c.convertStatToSchemaMetrics(completeStatMap, "dialog.expired_dialogs",
+		schemaMetric{c.dialog, "expired_dialogs", prometheus.CounterValue},
+		schemaMetric{c.dialogsTotal, "expired", prometheus.CounterValue}, metricChannel)
+	c.convertStatToSchemaMetrics(completeStatMap, "dialog.failed_dialogs",
+		schemaMetric{c.dialog, "failed_dialogs", prometheus.CounterValue},
+		schemaMetric{c.dialogsTotal, "failed", prometheus.CounterValue}, metricChannel)
+	c.convertStatToSchemaMetrics(completeStatMap, "dialog.processed_dialogs",
+		schemaMetric{c.dialog, "processed_dialogs", prometheus.CounterValue},
+		schemaMetric{c.dialogsTotal, "processed", prometheus.CounterValue}, metricChannel)
 }
 
 // Iterate all reported "stats" keys and find those with a prefix of "script."
 // These values are user-defined and populated within the kamailio script.
 // See https://www.kamailio.org/docs/modules/5.2.x/modules/statistics.html
-func convertScriptedMetrics(data map[string]string, prom chan<- prometheus.Metric) {
+// The stat name may carry label pairs separated by "__", e.g. "script.calls_total__trunk__carrierA".
+// Stats with an invalid name, or whose labels conflict with those of the same metric, are skipped
+// and counted by kamailio_scripted_metrics_invalid.
+func convertScriptedMetrics(data map[string]string, c *StatsFetchCollector, prom chan<- prometheus.Metric) {
+	// walk the stats in order, so that the same stat wins a conflict on every scrape
+	var keys []string
 	for k := range data {
-		// k = "script.custom_total"
 		if strings.HasPrefix(k, "script.") {
-			// metricName = "custom_total"
-			metricName := strings.TrimPrefix(k, "script.")
-			metricName = strings.ToLower(metricName)
-			var valueType prometheus.ValueType
-			// deduce the metrics value type by following https://prometheus.io/docs/practices/naming/
-			if strings.HasSuffix(k, "_total") || strings.HasSuffix(k, "_seconds") || strings.HasSuffix(k, "_bytes") {
-				valueType = prometheus.CounterValue
-			} else {
-				valueType = prometheus.GaugeValue
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	metrics := make(map[string]scriptedMetric)
+	series := make(map[string]string)
+	invalid := 0
+	for _, k := range keys {
+		// k = "script.custom_total" or "script.custom_total__trunk__carrierA"
+		metric, values, err := c.scriptedMetric(strings.TrimPrefix(k, "script."))
+		if err == nil {
+			err = c.checkReservedName("kamailio_" + metric.name)
+		}
+		if err == nil {
+			if previous, ok := metrics[metric.name]; ok {
+				err = previous.conflict(metric)
+			}
+		}
+		id := metric.name + "\xff" + strings.Join(values, "\xff")
+		if err == nil {
+			if previous, ok := series[id]; ok {
+				err = fmt.Errorf("same metric and labels as %q", previous)
 			}
-			// create a metric description on the fly
-			description := prometheus.NewDesc("kamailio_"+metricName, "Scripted metric "+metricName, []string{}, nil)
-			// and produce a metric
-			convertStatToMetric(data, k, "", description, prom, valueType)
 		}
+		if err != nil {
+			level.Warn(c.logger).Log("msg", "Skipping scripted stat", "stat", k, "err", err)
+			delete(data, k)
+			invalid++
+			continue
+		}
+		metrics[metric.name] = metric
+		series[id] = k
+
+		// create a metric description on the fly, which is unchecked as it is not sent by Describe
+		description := prometheus.NewDesc("kamailio_"+metric.name, metric.help, metric.labelNames, nil)
+		// and produce a metric
+		valueAsString := data[k]
+		delete(data, k)
+		value, err := strconv.ParseFloat(valueAsString, 64)
+		if err != nil {
+			level.Debug(c.logger).Log("msg", "Skipping a stat without numeric value", "stat", k, "value", valueAsString)
+			continue
+		}
+		prom <- prometheus.MustNewConstMetric(description, metric.valueType, value, values...)
+	}
+	prom <- prometheus.MustNewConstMetric(c.scriptedInvalid, prometheus.GaugeValue, float64(invalid))
+}
+
+// checkReservedName returns an error if a scripted metric takes the name of a metric described by
+// the collectors, or of a metric of the exporter itself, which would make the scrape fail.
+func (c *StatsFetchCollector) checkReservedName(fqName string) error {
+	if c.reservedNames[fqName] {
+		return fmt.Errorf("metric %s is already exported", fqName)
+	}
+	for _, prefix := range []string{"kamailio_exporter_", "kamailio_scrape_"} {
+		if strings.HasPrefix(fqName, prefix) {
+			return fmt.Errorf("metric %s has the %q prefix of the exporter metrics", fqName, prefix)
+		}
+	}
+	return nil
+}
+
+// scriptedMetric is the metric exported for the scripted stats, named without the "kamailio_" prefix.
+type scriptedMetric struct {
+	name       string
+	help       string
+	valueType  prometheus.ValueType
+	labelNames []string
+}
+
+// conflict returns an error if the metric of another scripted stat has the same name,
+// but not the same labels, help or type.
+func (m scriptedMetric) conflict(other scriptedMetric) error {
+	if !slices.Equal(m.labelNames, other.labelNames) {
+		return fmt.Errorf("labels %v conflict with labels %v of the same metric", other.labelNames, m.labelNames)
+	}
+	if m.help != other.help || m.valueType != other.valueType {
+		return fmt.Errorf("help or type conflicts with the same metric")
+	}
+	return nil
+}
+
+// scriptedMetric returns the metric of a scripted stat, as described by the first matching rule,
+// and its label values.
+func (c *StatsFetchCollector) scriptedMetric(stat string) (scriptedMetric, []string, error) {
+	metricName, labelNames, labelValues, err := parseScriptedStat(stat)
+	if err != nil {
+		return scriptedMetric{}, nil, err
+	}
+	var rule ScriptedMetricRule
+	for _, r := range c.scriptedMetrics {
+		if ok, _ := path.Match(r.Match, metricName); ok {
+			rule = r
+			break
+		}
+	}
+	if rule.Rename != "" {
+		metricName = rule.Rename
+	}
+	metric := scriptedMetric{name: withUnit(metricName, rule.Unit), help: rule.Help, labelNames: labelNames}
+	if metric.help == "" {
+		metric.help = "Scripted metric " + metric.name
+	}
+	if rule.Type != "" {
+		metric.valueType, _ = parseValueType(rule.Type)
+	} else if strings.HasSuffix(metric.name, "_total") || strings.HasSuffix(metric.name, "_seconds") || strings.HasSuffix(metric.name, "_bytes") {
+		// deduce the metrics value type by following https://prometheus.io/docs/practices/naming/
+		metric.valueType = prometheus.CounterValue
+	} else {
+		metric.valueType = prometheus.GaugeValue
+	}
+	return metric, labelValues, nil
+}
+
+// withUnit appends the unit to the metric name, before the "_total" suffix, unless the name already ends with it.
+func withUnit(metricName string, unit string) string {
+	if unit == "" {
+		return metricName
+	}
+	base, total := strings.CutSuffix(metricName, "_total")
+	if strings.HasSuffix(base, "_"+unit) {
+		return metricName
+	}
+	if total {
+		return base + "_" + unit + "_total"
+	}
+	return metricName + "_" + unit
+}
+
+// parseScriptedStat splits the name of a scripted stat into the lower-case metric name and
+// the label names and values, sorted by label name: "calls_total__trunk__carrierA" gives
+// "calls_total" with trunk="carrierA". The label values keep their case.
+func parseScriptedStat(stat string) (string, []string, []string, error) {
+	parts := strings.Split(stat, "__")
+	metricName := strings.ToLower(parts[0])
+	if metricName == "" || !model.IsValidMetricName(model.LabelValue("kamailio_"+metricName)) {
+		return "", nil, nil, fmt.Errorf("invalid metric name %q", metricName)
+	}
+	if len(parts)%2 == 0 {
+		return "", nil, nil, fmt.Errorf("label %q has no value", parts[len(parts)-1])
+	}
+	labels := make(map[string]string)
+	for i := 1; i < len(parts); i += 2 {
+		label := strings.ToLower(parts[i])
+		if !model.LabelName(label).IsValid() {
+			return "", nil, nil, fmt.Errorf("invalid label name %q", label)
+		}
+		if _, ok := labels[label]; ok {
+			return "", nil, nil, fmt.Errorf("label %q given twice", label)
+		}
+		if parts[i+1] == "" {
+			return "", nil, nil, fmt.Errorf("label %q has no value", label)
+		}
+		labels[label] = parts[i+1]
+	}
+	names := make([]string, 0, len(labels))
+	for label := range labels {
+		names = append(names, label)
+	}
+	slices.Sort(names)
+	values := make([]string, len(names))
+	for i, label := range names {
+		values[i] = labels[label]
+	}
+	return metricName, names, values, nil
+}
+
+// Export every stat left in the map, which has no dedicated metric, as kamailio_stat_gauge or kamailio_stat_counter_total
+// with its group and name as labels, e.g. "usrloc.registered_users".
+func convertGenericMetrics(data map[string]string, c *StatsFetchCollector, prom chan<- prometheus.Metric) {
+	for k := range data {
+		group, name, _ := strings.Cut(k, ".")
+		valueType := statValueType(c.statTypes, k)
+		description := c.stat
+		if valueType == prometheus.CounterValue {
+			description = c.statTotal
+		}
+		value, err := strconv.ParseFloat(data[k], 64)
+		if err != nil {
+			level.Debug(c.logger).Log("msg", "Skipping a stat without numeric value", "stat", k, "value", data[k])
+			continue
+		}
+		prom <- prometheus.MustNewConstMetric(description, valueType, value, group, name)
+	}
+}
+
+// fetchGroup adds the statistics of a group, or of all groups, to the map and reports the success and the duration of the fetch.
+func (c *StatsFetchCollector) fetchGroup(ctx context.Context, client Client, group string, completeStatMap map[string]string, metricChannel chan<- prometheus.Metric) error {
+	// stats.fetch takes a group as "group:", and a statistic name otherwise
+	param := group
+	if group != "all" {
+		param = group + ":"
+	}
+	begin := time.Now()
+	records, err := getRecords(ctx, client, c.logger, "stats.fetch", param)
+	metricChannel <- prometheus.MustNewConstMetric(c.groupDuration, prometheus.GaugeValue, time.Since(begin).Seconds(), group)
+	if err == nil && len(records) == 0 {
+		err = fmt.Errorf("no stats of group %q", group)
+	}
+	if err != nil {
+		metricChannel <- prometheus.MustNewConstMetric(c.groupSuccess, prometheus.GaugeValue, 0, group)
+		return err
+	}
+
+	items, _ := records[0].StructItems()
+	for _, item := range items {
+		value, _ := item.Value.String()
+		completeStatMap[item.Key] = value
+	}
+	metricChannel <- prometheus.MustNewConstMetric(c.groupSuccess, prometheus.GaugeValue, 1, group)
+	return nil
+}
+
+// schemaMetric is the metric of a stat in one schema.
+type schemaMetric struct {
+	desc       *prometheus.Desc
+	labelValue string
+	valueType  prometheus.ValueType
+}
+
+// convertStatToSchemaMetrics converts a stat, whose metric is corrected by the v2 schema,
+// to the metric of each emitted schema.
+func (c *StatsFetchCollector) convertStatToSchemaMetrics(completeStatMap map[string]string, statKey string, v1 schemaMetric, v2 schemaMetric, metricChannel chan<- prometheus.Metric) {
+	valueAsString, ok := completeStatMap[statKey]
+	if !ok {
+		return
+	}
+	if c.schemaV1 {
+		convertStatToMetric(completeStatMap, statKey, v1.labelValue, v1.desc, metricChannel, v1.valueType)
+	}
+	if c.schemaV2 {
+		completeStatMap[statKey] = valueAsString
+		convertStatToMetric(completeStatMap, statKey, v2.labelValue, v2.desc, metricChannel, v2.valueType)
 	}
 }
 
 // convert a single "stat" value to a prometheus metric
 // invalid "stat" paires are skipped but logged
+// the stat is removed from the map, so that the stats left are exported by the generic metrics
 func convertStatToMetric(completeStatMap map[string]string, statKey string, optionalLabelValue string, metricDescription *prometheus.Desc, metricChannel chan<- prometheus.Metric, valueType prometheus.ValueType) {
 	// check wether we got a labelValue or not
 	var labelValues []string
@@ -347,6 +804,7 @@ func convertStatToMetric(completeStatMap map[string]string, statKey string, opti
 	}
 	// get the stat-value ...
 	if valueAsString, ok := completeStatMap[statKey]; ok {
+		delete(completeStatMap, statKey)
 		// ... convert it to a float
 		if value, err := strconv.ParseFloat(valueAsString, 64); err == nil {
 			// and produce a prometheus metric
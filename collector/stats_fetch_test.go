@@ -0,0 +1,106 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package collector
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/go-kit/log"
+)
+
+func TestParseScriptedStat(t *testing.T) {
+	for _, tc := range []struct {
+		stat   string
+		name   string
+		labels []string
+		values []string
+		// err is empty when the stat is valid
+		err string
+	}{
+		{stat: "calls_total", name: "calls_total"},
+		{stat: "Calls_Total", name: "calls_total"},
+		{stat: "calls_total__trunk__carrierA", name: "calls_total", labels: []string{"trunk"}, values: []string{"carrierA"}},
+		{stat: "calls_total__Trunk__carrierA__region__EU", name: "calls_total", labels: []string{"region", "trunk"}, values: []string{"EU", "carrierA"}},
+		{stat: "calls_total__trunk", err: `label "trunk" has no value`},
+		{stat: "calls_total__trunk__a__region", err: `label "region" has no value`},
+		{stat: "calls_total__trunk__", err: `label "trunk" has no value`},
+		{stat: "calls_total____a", err: `invalid label name ""`},
+		{stat: "calls_total__trunk__a__Trunk__b", err: `label "trunk" given twice`},
+		{stat: "bad-name", err: `invalid metric name "bad-name"`},
+		{stat: "__trunk__a", err: `invalid metric name ""`},
+		{stat: "calls__bad-label__a", err: `invalid label name "bad-label"`},
+		{stat: "calls__1st__a", err: `invalid label name "1st"`},
+	} {
+		name, labels, values, err := parseScriptedStat(tc.stat)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("parseScriptedStat(%q) error = %v, want %q", tc.stat, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseScriptedStat(%q): %v", tc.stat, err)
+			continue
+		}
+		if name != tc.name || !slices.Equal(labels, tc.labels) || !slices.Equal(values, tc.values) {
+			t.Errorf("parseScriptedStat(%q) = %q, %q, %q, want %q, %q, %q", tc.stat, name, labels, values, tc.name, tc.labels, tc.values)
+		}
+	}
+}
+
+func TestScriptedMetricsLabelSets(t *testing.T) {
+	stats := []StructItem{
+		// the stats are sorted by name: the first variable gives the labels of the metric,
+		// and a label name in upper case is the same series as in lower case
+		{Key: "script.calls_total__TRUNK__a", Value: NewStringRecord("1")},
+		{Key: "script.calls_total__trunk__b", Value: NewStringRecord("2")},
+		{Key: "script.calls_total__trunk__c__region__eu", Value: NewStringRecord("3")},
+		{Key: "script.calls_total__zone__x", Value: NewStringRecord("4")},
+		{Key: "script.calls_total__trunk__a", Value: NewStringRecord("5")},
+		{Key: "script.calls_total__trunk__", Value: NewStringRecord("6")},
+	}
+	client := ClientFunc(func(_ context.Context, method string, args ...string) ([]Record, error) {
+		return []Record{NewStructRecord(stats...)}, nil
+	})
+	c, err := NewStatsFetchCollector(testConfig("tcp://localhost:2046", "stats.fetch"), log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := updateMetrics(t, c, client)
+	for key, want := range map[string]float64{
+		`kamailio_calls_total{trunk="a"}`:   1,
+		`kamailio_calls_total{trunk="b"}`:   2,
+		"kamailio_scripted_metrics_invalid": 4,
+	} {
+		if got, ok := metrics[key]; !ok || got != want {
+			t.Errorf("%s = %v (found: %v), want %v", key, got, ok, want)
+		}
+	}
+	for key := range metrics {
+		if key == `kamailio_calls_total{region="eu",trunk="c"}` || key == `kamailio_calls_total{zone="x"}` {
+			t.Errorf("got series %s, whose labels differ from the first variable", key)
+		}
+	}
+}
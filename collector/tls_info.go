@@ -22,7 +22,7 @@
 package collector
 
 import (
-	"net"
+	"context"
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
@@ -47,21 +47,28 @@ func NewTLSInfoCollector(config *KamailioCollectorConfig, logger log.Logger) (Co
 			prometheus.BuildFQName(namespace, "tls", "opened_connections"),
 			"TLS Opened Connections",
 			[]string{}, nil),
+		// kamailio_tls_max_connections is already exported by core.tcp_info
 		maxConnections: prometheus.NewDesc(
-			prometheus.BuildFQName(namespace, "tls", "max_connections"),
-			"TLS Opened Connections",
+			prometheus.BuildFQName(namespace, "tls_info", "max_connections"),
+			"TLS connection limit",
 			[]string{}, nil),
 		clearTextWrite: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, "tls", "clear_text_write_queued_bytes"),
-			"TLS Opened Connections",
+			"Clear text bytes queued to be written to TLS connections",
 			[]string{}, nil),
 		logger: logger,
 		config: config,
 	}, nil
 }
 
-func (c *TLSInfoCollector) Update(conn net.Conn, metricChannel chan<- prometheus.Metric) error {
-	records, err := getRecords(conn, c.logger, "tls.info")
+func (c *TLSInfoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.openedConnections
+	ch <- c.maxConnections
+	ch <- c.clearTextWrite
+}
+
+func (c *TLSInfoCollector) Update(ctx context.Context, client Client, metricChannel chan<- prometheus.Metric) error {
+	records, err := getRecords(ctx, client, c.logger, "tls.info")
 	if err != nil {
 		return err
 	}
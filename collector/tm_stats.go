@@ -22,7 +22,7 @@
 package collector
 
 import (
-	"net"
+	"context"
 	"regexp"
 
 	"github.com/go-kit/log"
@@ -73,8 +73,18 @@ func NewTmStatsCollector(config *KamailioCollectorConfig, logger log.Logger) (Co
 	}, nil
 }
 
-func (c *tmStatsCollector) Update(conn net.Conn, metricChannel chan<- prometheus.Metric) error {
-	records, err := getRecords(conn, c.logger, "tm.stats")
+func (c *tmStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.codes
+	for _, desc := range c.counters {
+		ch <- desc
+	}
+	for _, desc := range c.gauges {
+		ch <- desc
+	}
+}
+
+func (c *tmStatsCollector) Update(ctx context.Context, client Client, metricChannel chan<- prometheus.Metric) error {
+	records, err := getRecords(ctx, client, c.logger, "tm.stats")
 	if err != nil {
 		return err
 	}
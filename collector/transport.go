@@ -0,0 +1,284 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package collector
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"go.voiplens.io/kamailio/binrpc"
+)
+
+// Client runs RPC commands on Kamailio.
+type Client interface {
+	// Call runs the RPC method with the given arguments and returns the records of the reply.
+	// The call is abandoned when the context is done.
+	Call(ctx context.Context, method string, args ...string) ([]Record, error)
+}
+
+// ClientFunc is an adapter to use a function as a Client, e.g. to answer with in-memory records.
+type ClientFunc func(ctx context.Context, method string, args ...string) ([]Record, error)
+
+// Call calls f(ctx, method, args...).
+func (f ClientFunc) Call(ctx context.Context, method string, args ...string) ([]Record, error) {
+	return f(ctx, method, args...)
+}
+
+// Conn is a Client connected to Kamailio, which must be closed after use.
+type Conn interface {
+	Client
+	io.Closer
+}
+
+// transport is a Client connected to Kamailio, managed by the connection pool.
+type transport interface {
+	Client
+	// broken reports whether an error left the transport unusable.
+	broken() bool
+	// healthy checks that an idle transport can still be used.
+	healthy() bool
+	Close() error
+}
+
+// withContext applies the deadline and the cancellation of the context to the I/O of the connection,
+// until the returned function is called. A context already done fails before any I/O,
+// leaving the connection usable.
+func withContext(ctx context.Context, conn net.Conn) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		// unblock the pending I/O
+		conn.SetDeadline(time.Now())
+	})
+	return func() { stop() }, nil
+}
+
+// newDialer returns the function opening a transport to the Kamailio RPC URI.
+// The reply FIFOs and sockets of the local JSON-RPC transports are created in replyDir.
+func newDialer(u *url.URL, timeout time.Duration, replyDir string) (func(context.Context) (transport, error), error) {
+	switch u.Scheme {
+	case "tcp", "tcp4", "tcp6":
+		return func(ctx context.Context) (transport, error) {
+			return dialBinrpc(ctx, u.Scheme, u.Host, timeout)
+		}, nil
+	case "unix":
+		return func(ctx context.Context) (transport, error) {
+			return dialBinrpc(ctx, u.Scheme, u.Path, timeout)
+		}, nil
+	case "udp", "udp4", "udp6":
+		return func(ctx context.Context) (transport, error) {
+			return dialBinrpcDatagram(ctx, u.Scheme, u.Host, timeout)
+		}, nil
+	case "http", "https":
+		client := newHTTPClient(timeout)
+		return func(_ context.Context) (transport, error) {
+			return &httpTransport{client: client, url: u.String()}, nil
+		}, nil
+	case "unixgram":
+		return func(_ context.Context) (transport, error) {
+			return dialJSONRPCDatagram(u.Path, replyDir)
+		}, nil
+	case "fifo":
+		return func(_ context.Context) (transport, error) {
+			return openFIFO(u.Path, replyDir)
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported RPC URI scheme %q", u.Scheme)
+}
+
+// defaultReplyDir is the default "fifo_reply_dir" of jsonrpcs.
+const defaultReplyDir = "/tmp"
+
+// ValidateRPCURI returns an error if the URI can not be parsed or has no supported transport.
+func ValidateRPCURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("cannot parse URI: %w", err)
+	}
+	_, err = newDialer(u, 0, "")
+	return err
+}
+
+// binrpcTransport speaks BINRPC over a stream connection to the ctl module.
+type binrpcTransport struct {
+	net.Conn
+	failed bool
+}
+
+func dialBinrpc(ctx context.Context, network, address string, timeout time.Duration) (*binrpcTransport, error) {
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &binrpcTransport{Conn: conn}, nil
+}
+
+func (t *binrpcTransport) Call(ctx context.Context, method string, args ...string) ([]Record, error) {
+	done, err := withContext(ctx, t.Conn)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	cookie, err := binrpc.WritePacket(t, append([]string{method}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := binrpc.ReadPacket(t, cookie)
+	if err != nil {
+		return nil, err
+	}
+	return fromBinrpcRecords(records), nil
+}
+
+// Read and Write remember I/O errors, after which the BINRPC stream can not be trusted anymore.
+func (t *binrpcTransport) Read(b []byte) (int, error) {
+	n, err := t.Conn.Read(b)
+	if err != nil {
+		t.failed = true
+	}
+	return n, err
+}
+
+func (t *binrpcTransport) Write(b []byte) (int, error) {
+	n, err := t.Conn.Write(b)
+	if err != nil {
+		t.failed = true
+	}
+	return n, err
+}
+
+func (t *binrpcTransport) broken() bool {
+	return t.failed
+}
+
+// healthy checks that an idle connection has not been closed by Kamailio.
+// Nothing must be readable: a timeout means the peer is still there, while data or EOF means it is not.
+func (t *binrpcTransport) healthy() bool {
+	if t.failed {
+		return false
+	}
+	if err := t.Conn.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+		return false
+	}
+	var b [1]byte
+	_, err := t.Conn.Read(b[:])
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		return false
+	}
+	return t.Conn.SetReadDeadline(time.Time{}) == nil
+}
+
+// maxDatagramSize is the size of the largest reply read from a datagram socket.
+const maxDatagramSize = 65536
+
+// datagramConn sends each request in a single datagram and reads the reply from the next one.
+// A reply lost or received after the deadline is only detected by the next call,
+// so any error makes the connection unusable.
+type datagramConn struct {
+	net.Conn
+	// local is the path of the bound unix socket, removed on Close.
+	local  string
+	failed bool
+}
+
+func (c *datagramConn) roundTrip(ctx context.Context, request []byte) ([]byte, error) {
+	done, err := withContext(ctx, c.Conn)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	if _, err := c.Conn.Write(request); err != nil {
+		c.failed = true
+		return nil, err
+	}
+	reply := make([]byte, maxDatagramSize)
+	n, err := c.Conn.Read(reply)
+	if err != nil {
+		c.failed = true
+		return nil, err
+	}
+	return reply[:n], nil
+}
+
+func (c *datagramConn) broken() bool {
+	return c.failed
+}
+
+func (c *datagramConn) healthy() bool {
+	return !c.failed
+}
+
+func (c *datagramConn) Close() error {
+	err := c.Conn.Close()
+	if c.local != "" {
+		err = errors.Join(err, os.Remove(c.local))
+	}
+	return err
+}
+
+// binrpcDatagramTransport speaks BINRPC over UDP to the ctl module.
+type binrpcDatagramTransport struct {
+	*datagramConn
+}
+
+func dialBinrpcDatagram(ctx context.Context, network, address string, timeout time.Duration) (*binrpcDatagramTransport, error) {
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &binrpcDatagramTransport{&datagramConn{Conn: conn}}, nil
+}
+
+func (t *binrpcDatagramTransport) Call(ctx context.Context, method string, args ...string) ([]Record, error) {
+	var request bytes.Buffer
+	cookie, err := binrpc.WritePacket(&request, append([]string{method}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := t.roundTrip(ctx, request.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := binrpc.ReadPacket(bytes.NewReader(reply), cookie)
+	if err != nil {
+		return nil, err
+	}
+	return fromBinrpcRecords(records), nil
+}
@@ -0,0 +1,223 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package config
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/voiplens/kamailio_exporter/collector"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the content of the exporter configuration file.
+// Settings left out of the file fall back to the command-line flags.
+type Config struct {
+	// Target is the RPC URI of the Kamailio instance scraped by the metrics endpoint.
+	Target string `yaml:"target,omitempty"`
+	// ScrapeInterval enables the background scrapes of the target, ScrapeMaxAge limits how long
+	// the results of a failing collector are served.
+	ScrapeInterval time.Duration `yaml:"scrape_interval,omitempty"`
+	ScrapeMaxAge   time.Duration `yaml:"scrape_max_age,omitempty"`
+	// MinScrapeInterval is the minimum time between two scrapes of the target with the same collectors.
+	MinScrapeInterval time.Duration `yaml:"min_scrape_interval,omitempty"`
+	// The inline module holds the default settings, shared by the metrics endpoint and every probe module.
+	Module  `yaml:",inline"`
+	Modules map[string]Module `yaml:"modules,omitempty"`
+}
+
+// Module describes how a target is scraped.
+type Module struct {
+	Collectors        []string       `yaml:"collectors,omitempty"`
+	Timeout           time.Duration  `yaml:"timeout,omitempty"`
+	ReplyDir          string         `yaml:"reply_dir,omitempty"`
+	MaxConnections    int            `yaml:"max_connections,omitempty"`
+	DialogProfiles    []string       `yaml:"dialog_profiles,omitempty"`
+	DispatcherMapping map[int]string `yaml:"dispatcher_mapping,omitempty"`
+	// CollectorTimeouts limits the time given to each collector, within the timeout of the whole scrape.
+	CollectorTimeouts map[string]time.Duration `yaml:"collector_timeouts,omitempty"`
+	// MetricsSchema selects the names and types of the metrics, "v1", "v2" or "compat".
+	MetricsSchema string `yaml:"metrics_schema,omitempty"`
+	// StatGroups are the groups of statistics fetched one by one by stats.fetch.
+	StatGroups []string `yaml:"stat_groups,omitempty"`
+	// StatTypes gives the type of the stats.fetch statistics without a dedicated metric.
+	StatTypes []collector.StatTypeRule `yaml:"stat_types,omitempty"`
+	// ScriptedMetrics gives the type, help, unit and name of the scripted metrics.
+	ScriptedMetrics []collector.ScriptedMetricRule `yaml:"scripted_metrics,omitempty"`
+	// RPCCollectors declares collectors turning the reply of any RPC command into metrics.
+	RPCCollectors []collector.RPCCollectorConfig `yaml:"rpc_collectors,omitempty"`
+}
+
+// SafeConfig guards a Config shared between the HTTP handlers and the reload loop.
+type SafeConfig struct {
+	sync.RWMutex
+	C                   *Config
+	configReloadSuccess prometheus.Gauge
+	configReloadSeconds prometheus.Gauge
+}
+
+// NewSafeConfig returns a SafeConfig holding an empty configuration and registers its reload metrics.
+func NewSafeConfig(reg prometheus.Registerer) *SafeConfig {
+	configReloadSuccess := promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Namespace: "kamailio_exporter",
+		Name:      "config_last_reload_successful",
+		Help:      "Kamailio exporter config loaded successfully.",
+	})
+
+	configReloadSeconds := promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Namespace: "kamailio_exporter",
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Timestamp of the last successful configuration reload.",
+	})
+	return &SafeConfig{C: &Config{}, configReloadSuccess: configReloadSuccess, configReloadSeconds: configReloadSeconds}
+}
+
+// ReloadConfig loads the configuration file and swaps it in if it is valid. The optional apply function,
+// e.g. building the collector of the new configuration, runs before the swap and cancels it on error.
+func (sc *SafeConfig) ReloadConfig(confFile string, apply func(*Config) error) (err error) {
+	defer func() {
+		if err != nil {
+			sc.configReloadSuccess.Set(0)
+		} else {
+			sc.configReloadSuccess.Set(1)
+			sc.configReloadSeconds.SetToCurrentTime()
+		}
+	}()
+
+	c, err := LoadFile(confFile)
+	if err != nil {
+		return err
+	}
+	if apply != nil {
+		if err := apply(c); err != nil {
+			return err
+		}
+	}
+
+	sc.Lock()
+	sc.C = c
+	sc.Unlock()
+
+	return nil
+}
+
+// Config returns the current configuration. It must not be modified.
+func (sc *SafeConfig) Config() *Config {
+	sc.RLock()
+	defer sc.RUnlock()
+	return sc.C
+}
+
+// LoadFile parses the given YAML configuration file.
+func LoadFile(confFile string) (*Config, error) {
+	content, err := os.ReadFile(confFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(content, c); err != nil {
+		return nil, fmt.Errorf("error parsing config file: %w", err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file: %w", err)
+	}
+	return c, nil
+}
+
+func (c *Config) validate() error {
+	if c.Target != "" {
+		if err := collector.ValidateRPCURI(c.Target); err != nil {
+			return fmt.Errorf("target: %w", err)
+		}
+	}
+	if err := c.Module.validate(nil); err != nil {
+		return err
+	}
+	for name, module := range c.Modules {
+		// a module without its own rpc collectors uses the default ones
+		if err := module.validate(c.Module.RPCCollectors); err != nil {
+			return fmt.Errorf("module %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (m *Module) validate(defaultRPCCollectors []collector.RPCCollectorConfig) error {
+	if err := collector.ValidateCollectors(m.Collectors...); err != nil {
+		return err
+	}
+
+	if m.MetricsSchema != "" {
+		if err := collector.ValidateMetricsSchema(m.MetricsSchema); err != nil {
+			return fmt.Errorf("metrics_schema: %w", err)
+		}
+	}
+
+	if err := collector.ValidateStatGroups(m.StatGroups...); err != nil {
+		return fmt.Errorf("stat_groups: %w", err)
+	}
+
+	for _, rule := range m.StatTypes {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("stat_types: %w", err)
+		}
+	}
+
+	for _, rule := range m.ScriptedMetrics {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("scripted_metrics: %w", err)
+		}
+	}
+
+	rpcCollectors := make(map[string]bool)
+	for _, rc := range m.RPCCollectors {
+		if err := rc.Validate(); err != nil {
+			return fmt.Errorf("rpc_collectors: %w", err)
+		}
+		if rpcCollectors[rc.CollectorName()] {
+			return fmt.Errorf("rpc_collectors: collector %q is declared twice", rc.CollectorName())
+		}
+		rpcCollectors[rc.CollectorName()] = true
+	}
+	if len(m.RPCCollectors) == 0 {
+		for _, rc := range defaultRPCCollectors {
+			rpcCollectors[rc.CollectorName()] = true
+		}
+	}
+
+	for name, timeout := range m.CollectorTimeouts {
+		if !rpcCollectors[name] {
+			if err := collector.ValidateCollectors(name); err != nil {
+				return fmt.Errorf("collector_timeouts: %w", err)
+			}
+		}
+		if timeout <= 0 {
+			return fmt.Errorf("collector_timeouts: timeout of collector %q must be positive", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes the content to a configuration file of a temporary directory and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const mqueueCollector = `
+  - name: mqueue_sizes
+    method: mqueue.get_sizes
+    path: ["*"]
+    labels: {queue: name}
+    metrics:
+      - {name: mqueue_size, field: size}
+`
+
+func TestLoadFileErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		// err is a part of the error, empty when the file is valid
+		err string
+	}{
+		{"unknown key", "timeout: 5s\nunknown_setting: 1\n", "field unknown_setting not found"},
+		{"unknown module key", "modules:\n  edge:\n    target: tcp://10.0.0.5:2046\n", "field target not found"},
+		{"unsupported target scheme", "target: ftp://10.0.0.5:2046\n", `target: unsupported RPC URI scheme "ftp"`},
+		{"unparsable target", "target: \"tcp://[::1\"\n", "target: cannot parse URI"},
+		{"valid targets", "target: tcp://10.0.0.5:2046\n", ""},
+		{"unknown metrics schema", "metrics_schema: v3\n", `metrics_schema: unknown metrics schema "v3"`},
+		{"unknown module metrics schema", "modules:\n  edge:\n    metrics_schema: v3\n", `module "edge": metrics_schema`},
+		{"unknown collector", "collectors: [stats.fetch, unknown]\n", `unknown collector "unknown"`},
+		{"duplicate rpc collectors", "rpc_collectors:" + mqueueCollector + mqueueCollector, `rpc_collectors: collector "mqueue_sizes" is declared twice`},
+		{"rpc collector named like a built-in one", "rpc_collectors:\n  - name: stats.fetch\n    method: stats.fetch\n    metrics: [{name: x, field: y}]\n", "name is already used by a built-in collector"},
+		{"timeout of an unknown collector", "collector_timeouts:\n  unknown: 2s\n", `collector_timeouts: unknown collector "unknown"`},
+		{"zero timeout", "collector_timeouts:\n  dispatcher.list: 0s\n", `timeout of collector "dispatcher.list" must be positive`},
+		{"negative timeout", "collector_timeouts:\n  dispatcher.list: -1s\n", `timeout of collector "dispatcher.list" must be positive`},
+		{"timeout of an rpc collector", "rpc_collectors:" + mqueueCollector + "collector_timeouts:\n  mqueue_sizes: 1s\n", ""},
+		{"timeout of a default rpc collector in a module", "rpc_collectors:" + mqueueCollector + "modules:\n  edge:\n    collector_timeouts:\n      mqueue_sizes: 1s\n", ""},
+		{"invalid stat group", "stat_groups: [core, \"tm:\"]\n", `stat_groups: invalid stat group "tm:"`},
+	} {
+		_, err := LoadFile(writeConfig(t, tc.content))
+		if tc.err == "" && err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s: got error %v, want %q", tc.name, err, tc.err)
+		}
+	}
+}
+
+// TestLoadFileReadme loads the configuration examples of the README, except the Prometheus ones.
+func TestLoadFileReadme(t *testing.T) {
+	readme, err := os.ReadFile("../README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	examples := regexp.MustCompile("(?s)```yaml\n(.*?)```").FindAllStringSubmatch(string(readme), -1)
+	loaded := 0
+	for _, example := range examples {
+		if strings.Contains(example[1], "scrape_configs:") {
+			continue
+		}
+		c, err := LoadFile(writeConfig(t, example[1]))
+		if err != nil {
+			t.Errorf("README example %q: %v", strings.SplitN(strings.TrimSpace(example[1]), "\n", 2)[0], err)
+			continue
+		}
+		loaded++
+		if c.Target == "unix:///var/run/kamailio/kamailio_ctl" && (c.Timeout != 5*time.Second || c.CollectorTimeouts["dispatcher.list"] != 2*time.Second) {
+			t.Errorf("README example loaded as %+v", c)
+		}
+	}
+	if loaded < 5 {
+		t.Errorf("loaded %d configuration examples of the README, want at least 5", loaded)
+	}
+}
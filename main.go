@@ -23,10 +23,16 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/go-kit/log"
@@ -41,6 +47,7 @@ import (
 	"github.com/prometheus/exporter-toolkit/web"
 	webflag "github.com/prometheus/exporter-toolkit/web/kingpinflag"
 	"github.com/voiplens/kamailio_exporter/collector"
+	"github.com/voiplens/kamailio_exporter/config"
 )
 
 func init() {
@@ -51,9 +58,20 @@ var Version string
 
 func AddFlags(a *kingpin.Application) *collector.KamailioCollectorConfig {
 	config := &collector.KamailioCollectorConfig{}
-	config.BinrpcURI = a.Flag("kamailio.binrpc-uri", `BINRPC URI on which to scrape kamailio. E.g. "tcp://localhost:3012"`).Default("unix:///var/run/kamailio/kamailio_ctl").String()
-	config.Timeout = a.Flag("kamailio.timeout", "Timeout for trying to get stats from Kamailio using BINRPC.").Short('t').Default("5s").Duration()
+	config.RPCURI = new(string)
+	a.Flag("kamailio.rpc-uri", `RPC URI on which to scrape kamailio: BINRPC with "unix://", "tcp://" or "udp://", JSON-RPC with "http://", "https://", "fifo://" or "unixgram://". E.g. "tcp://localhost:3012"`).Default("unix:///var/run/kamailio/kamailio_ctl").StringVar(config.RPCURI)
+	a.Flag("kamailio.binrpc-uri", "Deprecated alias of --kamailio.rpc-uri.").Hidden().StringVar(config.RPCURI)
+	config.ReplyDir = a.Flag("kamailio.reply-dir", `Directory in which the reply FIFOs and sockets of the "fifo://" and "unixgram://" RPC URIs are created. It must match the "fifo_reply_dir" of jsonrpcs for the FIFO.`).Default("/tmp").String()
+	config.Timeout = a.Flag("kamailio.timeout", "Timeout for trying to get stats from Kamailio.").Short('t').Default("5s").Duration()
+	config.MaxConnections = a.Flag("kamailio.max-connections", "Maximum number of RPC connections, used to run the collectors concurrently.").Default("1").Int()
+	config.ScrapeInterval = a.Flag("kamailio.scrape-interval", "Interval at which Kamailio is scraped in the background, the metrics endpoint then serves the last results. Kamailio is scraped on every request when 0.").Default("0s").Duration()
+	config.ScrapeMaxAge = a.Flag("kamailio.scrape-max-age", "How long the metrics of a collector are still served after failed background scrapes.").Default("1m").Duration()
+	config.MinScrapeInterval = a.Flag("kamailio.min-scrape-interval", "Minimum interval between two runs of a collector against Kamailio, requests in between get the results of its previous run.").Default("0s").Duration()
 	config.DialogProfile.Profiles = a.Flag("collector.dialog.profiles", "Select dialog profiles to query.").Default("").Strings()
+	config.MetricsSchema = a.Flag("metrics.schema", `Names and types of the metrics: "v1" keeps the original ones, "v2" emits the corrected ones and "compat" emits both during a migration.`).Default("v1").Enum(collector.MetricsSchemas...)
+	config.StatGroups = a.Flag("collector.stats.fetch.group", `Group of statistics fetched by stats.fetch, e.g. "core" or "shmem". Repeatable, each group is fetched by its own RPC call. All the statistics are fetched at once by default.`).Strings()
+	config.RecordDir = a.Flag("debug.record-dir", "Directory in which the RPC replies of Kamailio are recorded, one JSON file per command.").Default("").String()
+	config.ReplayDir = a.Flag("kamailio.replay-dir", "Directory of RPC replies recorded with --debug.record-dir, served instead of scraping Kamailio.").Default("").String()
 	return config
 }
 
@@ -76,26 +94,153 @@ func main() {
 			"collector.dispatcher.mapping",
 			`Map a Dispatcher ID to a Name using the "ID:NAME" format. E.g. "100:Genesys"`,
 		).Default("").Strings()
+		timeoutOffset = kingpin.Flag(
+			"kamailio.timeout-offset",
+			"Offset to subtract from the scrape timeout sent by Prometheus in the X-Prometheus-Scrape-Timeout-Seconds header.",
+		).Default("500ms").Duration()
+		collectorTimeouts = kingpin.Flag(
+			"collector.timeout",
+			`Timeout of a collector using the "NAME:DURATION" format, e.g. "dispatcher.list:2s". Defaults to the whole --kamailio.timeout.`,
+		).Strings()
+		statTypes = kingpin.Flag(
+			"collector.stats.fetch.type",
+			`Type of the stats.fetch statistics exported by kamailio_stat_gauge and kamailio_stat_counter_total, using the "PATTERN:TYPE" format with a glob pattern and "gauge" or "counter". E.g. "registrar.*_regs:counter".`,
+		).Strings()
+		configFile = kingpin.Flag(
+			"config.file",
+			"Path to the exporter configuration file. It is reloaded on SIGHUP or a POST to /-/reload.",
+		).Default("").String()
+		disableDefaultCollectors = kingpin.Flag(
+			"collector.disable-defaults",
+			"Set all collectors to disabled by default.",
+		).Default("false").Bool()
 		collectorConfig = AddFlags(kingpin.CommandLine)
+
+		rpcCommand = kingpin.Command("rpc", "Run an RPC command on Kamailio and print the reply, e.g. \"rpc stats.fetch all\".")
+		rpcMethod  = rpcCommand.Arg("method", "RPC method to run.").Required().String()
+		rpcArgs    = rpcCommand.Arg("args", "Arguments of the RPC method.").Strings()
+		rpcOutput  = rpcCommand.Flag("output", "Output format of the reply.").Short('o').Default("json").Enum("json", "yaml")
+
+		checkCommand = kingpin.Command("check", "Run the collectors once and check the value of a metric, as a Nagios plugin.")
+		checkMetric  = checkCommand.Flag("metric", "Name of the metric to check, e.g. kamailio_dlg_stats_active_all.").Required().String()
+		checkLabels  = checkCommand.Flag("label", `Only check the series having this label value, using the "NAME=VALUE" format. Repeatable.`).StringMap()
+		checkWarn    = checkCommand.Flag("warn", `Warning threshold, in the Nagios range format, e.g. "800", "10:" or "@10:20".`).String()
+		checkCrit    = checkCommand.Flag("crit", `Critical threshold, in the Nagios range format, e.g. "1000", "5:" or "@0:5".`).String()
 	)
 
+	kingpin.Command("serve", "Serve the metrics of Kamailio. This is the default command.").Default()
+
 	promlogConfig := &promlog.Config{}
 	flag.AddFlags(kingpin.CommandLine, promlogConfig)
 	kingpin.Version(version.Print("kamailio_exporter"))
-	kingpin.Parse()
+	command := kingpin.Parse()
 	logger := promlog.New(promlogConfig)
 
+	if command == rpcCommand.FullCommand() {
+		os.Exit(runRPC(collectorConfig, *rpcMethod, *rpcArgs, *rpcOutput, logger))
+	}
+
+	if *disableDefaultCollectors {
+		collector.DisableDefaultCollectors()
+	}
+
+	collectorConfig.DispatcherMap = collector.ParseDispatcherMapping(dispatcherMap, logger)
+	collectorConfig.CollectorTimeouts = collector.ParseCollectorTimeouts(collectorTimeouts, logger)
+	collectorConfig.StatTypes = collector.ParseStatTypes(statTypes, logger)
+	if err := collector.ValidateStatGroups(*collectorConfig.StatGroups...); err != nil {
+		level.Error(logger).Log("msg", "Invalid --collector.stats.fetch.group flag", "err", err)
+		os.Exit(1)
+	}
+
+	if command == checkCommand.FullCommand() {
+		os.Exit(runCheck(collectorConfig, checkOptions{
+			metric:     *checkMetric,
+			labels:     *checkLabels,
+			warn:       *checkWarn,
+			crit:       *checkCrit,
+			configFile: *configFile,
+		}, logger))
+	}
+
 	level.Info(logger).Log("msg", "Starting kamailio_exporter", "version", version.Info())
 	level.Info(logger).Log("msg", "Build context", "build_context", version.BuildContext())
 
-	collectorConfig.DispatcherMap = collector.ParseDispatcherMapping(dispatcherMap, logger)
-	c, err := collector.NewKamailioCollector(collectorConfig, logger)
+	// the reload metrics are only meaningful with a configuration file
+	var reloadRegisterer prometheus.Registerer
+	if *configFile != "" {
+		reloadRegisterer = prometheus.DefaultRegisterer
+	}
+	sc := config.NewSafeConfig(reloadRegisterer)
+	if *configFile != "" {
+		if err := sc.ReloadConfig(*configFile, nil); err != nil {
+			level.Error(logger).Log("msg", "Error loading config", "err", err)
+			os.Exit(1)
+		}
+		level.Info(logger).Log("msg", "Loaded config file", "file", *configFile)
+	}
 
+	if *collectorConfig.ReplayDir != "" {
+		level.Info(logger).Log("msg", "Serving the recorded RPC replies instead of scraping Kamailio", "dir", *collectorConfig.ReplayDir)
+	} else if *collectorConfig.RecordDir != "" {
+		level.Info(logger).Log("msg", "Recording the RPC replies", "dir", *collectorConfig.RecordDir)
+	}
+
+	c, err := collector.NewKamailioCollector(scrapeConfig(collectorConfig, sc.Config()), logger)
 	if err != nil {
-		panic(err)
+		level.Error(logger).Log("msg", "Error creating the collector", "err", err)
+		os.Exit(1)
 	}
 
-	prometheus.MustRegister(c)
+	metrics := &metricsHandler{collector: &servedCollector{KamailioCollector: c}, customMetricsURL: *customMetricsURL, timeoutOffset: *timeoutOffset, logger: logger}
+
+	reload := func() error {
+		if *configFile == "" {
+			return fmt.Errorf("no configuration file to reload")
+		}
+		// the new configuration is only swapped in once its collector is built
+		var c *collector.KamailioCollector
+		err := sc.ReloadConfig(*configFile, func(conf *config.Config) (err error) {
+			c, err = collector.NewKamailioCollector(scrapeConfig(collectorConfig, conf), logger)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		metrics.replaceCollector(c)
+		return nil
+	}
+
+	hup := make(chan os.Signal, 1)
+	term := make(chan os.Signal, 1)
+	reloadCh := make(chan chan error)
+	signal.Notify(hup, syscall.SIGHUP)
+	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for {
+			select {
+			case <-term:
+				// closing the connections removes the reply FIFOs and sockets of the local transports
+				level.Info(logger).Log("msg", "Received termination signal, exiting")
+				metrics.mtx.RLock()
+				metrics.collector.Close()
+				os.Exit(0)
+			case <-hup:
+				if err := reload(); err != nil {
+					level.Error(logger).Log("msg", "Error reloading config", "err", err)
+					continue
+				}
+				level.Info(logger).Log("msg", "Reloaded config file")
+			case rc := <-reloadCh:
+				if err := reload(); err != nil {
+					level.Error(logger).Log("msg", "Error reloading config", "err", err)
+					rc <- err
+				} else {
+					level.Info(logger).Log("msg", "Reloaded config file")
+					rc <- nil
+				}
+			}
+		}
+	}()
 
 	if *metricsPath != "/" && *metricsPath != "" {
 		landingConfig := web.LandingConfig{
@@ -143,11 +288,25 @@ func main() {
 		})
 	}
 
-	if *customMetricsURL != "" {
-		http.Handle(*metricsPath, handlerWithUserDefinedMetrics(*customMetricsURL, logger))
-	} else {
-		http.Handle(*metricsPath, promhttp.Handler())
-	}
+	http.Handle(*metricsPath, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, metrics))
+	http.HandleFunc("/probe", func(w http.ResponseWriter, r *http.Request) {
+		probeHandler(w, r, sc, collectorConfig, *timeoutOffset, logger)
+	})
+
+	http.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			fmt.Fprintf(w, "This endpoint requires a POST request.\n")
+			return
+		}
+
+		rc := make(chan error)
+		reloadCh <- rc
+		if err := <-rc; err != nil {
+			http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+		}
+	})
+
 	server := &http.Server{}
 	if err := web.ListenAndServe(server, toolkitFlags, logger); err != nil {
 		level.Info(logger).Log("err", err)
@@ -188,22 +347,91 @@ func gatherUserDefinedMetrics(url string, logger log.Logger) ([]*dto.MetricFamil
 	return result, nil
 }
 
-func handlerWithUserDefinedMetrics(userDefinedMetricsURL string, logger log.Logger) http.Handler {
-	gatherer := func() ([]*dto.MetricFamily, error) {
-		ours, err := prometheus.DefaultGatherer.Gather()
-		if err != nil {
-			return ours, err
-		}
+// userDefinedGatherer returns the user defined metrics, or nothing if they can not be scraped.
+func userDefinedGatherer(userDefinedMetricsURL string, logger log.Logger) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
 		theirs, err := gatherUserDefinedMetrics(userDefinedMetricsURL, logger)
 		if err != nil {
 			level.Error(logger).Log("msg", "Scraping user defined metrics failed", "err", err)
-			return ours, nil
+			return nil, nil
 		}
-		return append(ours, theirs...), nil
+		return theirs, nil
+	})
+}
+
+// metricsHandler serves the exporter metrics along with the Kamailio collectors
+// selected by the "collect[]" and "exclude[]" URL parameters.
+type metricsHandler struct {
+	mtx              sync.RWMutex
+	collector        *servedCollector
+	customMetricsURL string
+	timeoutOffset    time.Duration
+	logger           log.Logger
+}
+
+// servedCollector is the collector of the metrics endpoint, with the scrapes using it.
+type servedCollector struct {
+	*collector.KamailioCollector
+	scrapes sync.WaitGroup
+}
+
+// replaceCollector replaces the collector after a configuration reload.
+// The previous collector keeps its connections until its running scrapes are over.
+func (h *metricsHandler) replaceCollector(c *collector.KamailioCollector) {
+	h.mtx.Lock()
+	previous := h.collector
+	h.collector = &servedCollector{KamailioCollector: c}
+	h.mtx.Unlock()
+
+	go func() {
+		previous.scrapes.Wait()
+		previous.Close()
+	}()
+}
+
+func (h *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the scrape is counted before the collector can be replaced
+	h.mtx.RLock()
+	kc := h.collector
+	kc.scrapes.Add(1)
+	h.mtx.RUnlock()
+	defer kc.scrapes.Done()
+
+	params := r.URL.Query()
+	c, err := kc.Filter(params["collect[]"], params["exclude[]"])
+	if err != nil {
+		level.Warn(h.logger).Log("msg", "Invalid collector selection", "err", err)
+		http.Error(w, fmt.Sprintf("Invalid collector selection: %s", err), http.StatusBadRequest)
+		return
 	}
 
-	// defaults like promhttp.Handler(), except using our own gatherer
-	return promhttp.InstrumentMetricHandler(
-		prometheus.DefaultRegisterer,
-		promhttp.HandlerFor(prometheus.GathererFunc(gatherer), promhttp.HandlerOpts{}))
+	ctx, cancel := scrapeContext(r, h.timeoutOffset, h.logger)
+	defer cancel()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c.WithContext(ctx))
+
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, registry}
+	if h.customMetricsURL != "" {
+		gatherers = append(gatherers, userDefinedGatherer(h.customMetricsURL, h.logger))
+	}
+	promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// scrapeContext returns the context of a scrape, which ends before the scrape timeout
+// sent by Prometheus in the X-Prometheus-Scrape-Timeout-Seconds header, minus the offset.
+func scrapeContext(r *http.Request, offset time.Duration, logger log.Logger) (context.Context, context.CancelFunc) {
+	header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if header == "" {
+		return context.WithCancel(r.Context())
+	}
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil || seconds <= 0 {
+		level.Warn(logger).Log("msg", "Invalid X-Prometheus-Scrape-Timeout-Seconds header", "value", header, "err", err)
+		return context.WithCancel(r.Context())
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > offset {
+		timeout -= offset
+	}
+	return context.WithTimeout(r.Context(), timeout)
 }
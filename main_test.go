@@ -0,0 +1,101 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/voiplens/kamailio_exporter/collector"
+	"github.com/voiplens/kamailio_exporter/collector/binrpctest"
+)
+
+func newTestCollector(t *testing.T, uri string, collectors ...string) *collector.KamailioCollector {
+	t.Helper()
+	timeout := 5 * time.Second
+	maxConnections := 1
+	config := &collector.KamailioCollectorConfig{
+		RPCURI:         &uri,
+		Timeout:        &timeout,
+		MaxConnections: &maxConnections,
+		Collectors:     make(map[string]bool),
+	}
+	for _, name := range collectors {
+		config.Collectors[name] = true
+	}
+	c, err := collector.NewKamailioCollector(config, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestMetricsHandlerReplaceCollector(t *testing.T) {
+	server := binrpctest.NewServer()
+	defer server.Close()
+	// the first collector holds the single connection until the collector is replaced,
+	// and the other one waits for it
+	calling := make(chan struct{})
+	var once sync.Once
+	unblock := make(chan struct{})
+	blocking := func(records ...any) binrpctest.Handler {
+		return func(...string) ([]any, error) {
+			once.Do(func() { close(calling) })
+			<-unblock
+			return records, nil
+		}
+	}
+	server.Handle("stats.fetch", blocking(binrpctest.Struct{{Name: "core.rcv_requests", Value: "10"}}))
+	server.Handle("dlg.stats_active", blocking(binrpctest.Struct{{Name: "all", Value: 3}}))
+
+	metrics := &metricsHandler{
+		collector: &servedCollector{KamailioCollector: newTestCollector(t, server.URI, "stats.fetch", "dlg.stats_active")},
+		logger:    log.NewNopLogger(),
+	}
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		metrics.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	}()
+
+	<-calling
+	next := newTestCollector(t, server.URI, "stats.fetch", "dlg.stats_active")
+	defer next.Close()
+	metrics.replaceCollector(next)
+	close(unblock)
+	<-done
+
+	for _, want := range []string{
+		`kamailio_scrape_collector_success{collector="stats.fetch"} 1`,
+		`kamailio_scrape_collector_success{collector="dlg.stats_active"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("scrape during a reload lacks %s:\n%s", want, w.Body.String())
+		}
+	}
+}
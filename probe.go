@@ -0,0 +1,161 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/voiplens/kamailio_exporter/collector"
+	"github.com/voiplens/kamailio_exporter/config"
+)
+
+// defaultModule is used when the probe request does not name a module.
+// Unless the configuration file defines it, it only uses the default settings.
+const defaultModule = "default"
+
+// probeSchemes are the schemes of the targets a probe may scrape. Probes only reach Kamailio over the network,
+// so that the callers of /probe can not write into the local sockets and FIFOs of the exporter host.
+var probeSchemes = []string{"tcp", "tcp4", "tcp6", "udp", "udp4", "udp6", "http", "https"}
+
+// probeHandler scrapes the Kamailio instance given by the "target" RPC URI,
+// using the collectors, timeout and dialog profiles of the requested module.
+func probeHandler(w http.ResponseWriter, r *http.Request, sc *config.SafeConfig, flags *collector.KamailioCollectorConfig, timeoutOffset time.Duration, logger log.Logger) {
+	params := r.URL.Query()
+
+	target := params.Get("target")
+	if target == "" {
+		http.Error(w, "Target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	if u, err := url.Parse(target); err != nil || !slices.Contains(probeSchemes, u.Scheme) {
+		level.Debug(logger).Log("msg", "Unsupported probe target", "target", target)
+		http.Error(w, fmt.Sprintf("Unsupported target %q, the scheme must be one of %s", target, strings.Join(probeSchemes, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	moduleName := params.Get("module")
+	if moduleName == "" {
+		moduleName = defaultModule
+	}
+	conf := sc.Config()
+	module, ok := conf.Modules[moduleName]
+	if !ok && moduleName != defaultModule {
+		level.Debug(logger).Log("msg", "Unknown module", "module", moduleName)
+		http.Error(w, fmt.Sprintf("Unknown module %q", moduleName), http.StatusBadRequest)
+		return
+	}
+
+	logger = log.With(logger, "module", moduleName, "target", target)
+	collectorConfig := applyModule(applyModule(flags, conf.Module), module)
+	collectorConfig.RPCURI = &target
+	// probes are never served from a background scrape, nor recorded or replayed
+	collectorConfig.ScrapeInterval = nil
+	collectorConfig.RecordDir = nil
+	collectorConfig.ReplayDir = nil
+	c, err := collector.NewKamailioCollector(collectorConfig, logger)
+	if err != nil {
+		level.Error(logger).Log("msg", "Can not create the probe collector", "err", err)
+		http.Error(w, fmt.Sprintf("Can not create the probe collector: %s", err), http.StatusBadRequest)
+		return
+	}
+	defer c.Close()
+
+	ctx, cancel := scrapeContext(r, timeoutOffset, logger)
+	defer cancel()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c.WithContext(ctx))
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// scrapeConfig returns the collector configuration of the metrics endpoint,
+// made of the command-line flags overridden by the configuration file.
+func scrapeConfig(flags *collector.KamailioCollectorConfig, conf *config.Config) *collector.KamailioCollectorConfig {
+	c := applyModule(flags, conf.Module)
+	if conf.Target != "" {
+		target := conf.Target
+		c.RPCURI = &target
+	}
+	if conf.ScrapeInterval > 0 {
+		c.ScrapeInterval = &conf.ScrapeInterval
+	}
+	if conf.ScrapeMaxAge > 0 {
+		c.ScrapeMaxAge = &conf.ScrapeMaxAge
+	}
+	if conf.MinScrapeInterval > 0 {
+		c.MinScrapeInterval = &conf.MinScrapeInterval
+	}
+	return c
+}
+
+// applyModule returns a copy of the collector configuration overridden by the settings of the module.
+func applyModule(defaults *collector.KamailioCollectorConfig, module config.Module) *collector.KamailioCollectorConfig {
+	c := *defaults
+	if module.Timeout > 0 {
+		c.Timeout = &module.Timeout
+	}
+	if module.ReplyDir != "" {
+		c.ReplyDir = &module.ReplyDir
+	}
+	if module.MaxConnections > 0 {
+		c.MaxConnections = &module.MaxConnections
+	}
+	if len(module.DialogProfiles) > 0 {
+		c.DialogProfile.Profiles = &module.DialogProfiles
+	}
+	if len(module.DispatcherMapping) > 0 {
+		c.DispatcherMap = module.DispatcherMapping
+	}
+	if len(module.CollectorTimeouts) > 0 {
+		c.CollectorTimeouts = module.CollectorTimeouts
+	}
+	if module.MetricsSchema != "" {
+		c.MetricsSchema = &module.MetricsSchema
+	}
+	if len(module.StatGroups) > 0 {
+		c.StatGroups = &module.StatGroups
+	}
+	if len(module.StatTypes) > 0 {
+		c.StatTypes = module.StatTypes
+	}
+	if len(module.ScriptedMetrics) > 0 {
+		c.ScriptedMetrics = module.ScriptedMetrics
+	}
+	if len(module.RPCCollectors) > 0 {
+		c.RPCCollectors = module.RPCCollectors
+	}
+	if len(module.Collectors) > 0 {
+		c.Collectors = make(map[string]bool)
+		for _, name := range module.Collectors {
+			c.Collectors[name] = true
+		}
+	}
+	return &c
+}
@@ -0,0 +1,61 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/voiplens/kamailio_exporter/collector"
+	"github.com/voiplens/kamailio_exporter/config"
+)
+
+func TestProbeHandlerTargetScheme(t *testing.T) {
+	sc := config.NewSafeConfig(nil)
+	for _, tc := range []struct {
+		target string
+		reply  string
+	}{
+		{"", "Target parameter is missing"},
+		{"fifo:///tmp/kamailio_rpc.fifo", "Unsupported target"},
+		{"unixgram:///var/run/kamailio/kamailio_rpc.sock", "Unsupported target"},
+		{"unix:///var/run/kamailio/kamailio_ctl", "Unsupported target"},
+		{"/var/run/kamailio/kamailio_ctl", "Unsupported target"},
+		{"%zz", "Unsupported target"},
+		// network targets get past the target check, up to the unknown module
+		{"tcp://127.0.0.1:2046", "Unknown module"},
+		{"udp://127.0.0.1:2046", "Unknown module"},
+		{"http://127.0.0.1:5060/RPC", "Unknown module"},
+	} {
+		query := url.Values{"target": {tc.target}, "module": {"unknown"}}
+		req := httptest.NewRequest(http.MethodGet, "/probe?"+query.Encode(), nil)
+		w := httptest.NewRecorder()
+		probeHandler(w, req, sc, &collector.KamailioCollectorConfig{}, 0, log.NewNopLogger())
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tc.reply) {
+			t.Errorf("target %q: got %d %q, want 400 %q", tc.target, w.Code, w.Body.String(), tc.reply)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// MIT License
+
+// Copyright (c) 2023 Yann Vigara, Angarium Limited
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-kit/log"
+	"github.com/voiplens/kamailio_exporter/collector"
+	"gopkg.in/yaml.v2"
+)
+
+// runRPC runs an RPC command on Kamailio, like kamcmd does, and prints the records of the reply.
+// It returns the exit code of the exporter.
+func runRPC(config *collector.KamailioCollectorConfig, method string, args []string, output string, logger log.Logger) int {
+	conn, err := collector.Dial(config, logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can not connect to kamailio: %s\n", err)
+		return 1
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *config.Timeout)
+	defer cancel()
+	records, err := conn.Call(ctx, method, args...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %s\n", method, err)
+		return 1
+	}
+	if err := printRecords(os.Stdout, records, output); err != nil {
+		fmt.Fprintf(os.Stderr, "Can not print the reply: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// printRecords writes the records in the output format. A reply made of a single record,
+// as most commands return, is printed as that record rather than a list.
+func printRecords(w io.Writer, records []collector.Record, output string) error {
+	var value interface{} = records
+	if len(records) == 0 {
+		value = []collector.Record{}
+	} else if len(records) == 1 {
+		value = records[0]
+	}
+
+	switch output {
+	case "yaml":
+		content, err := yaml.Marshal(value)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(content)
+		return err
+	default:
+		content, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", content)
+		return err
+	}
+}